@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/filswan/go-swan-lib/model"
+	"golang.org/x/crypto/sha3"
+)
+
+// ParseAddress parses a wallet or provider address given by the user: a
+// Filecoin address, or a 0x Ethereum address. Ethereum addresses are converted
+// to their f410 address, or to the id address they mask. Delegated addresses
+// must be f410 addresses of the Ethereum address manager.
+func ParseAddress(s string) (address.Address, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		ethAddr, err := parseEthAddress(s)
+		if err != nil {
+			return address.Undef, err
+		}
+		addr, err := ethAddr.ToFilecoinAddress()
+		if err != nil {
+			return address.Undef, fmt.Errorf("converting %s to a filecoin address: %w", s, err)
+		}
+		return addr, nil
+	}
+
+	addr, err := address.NewFromString(s)
+	if err != nil {
+		return address.Undef, fmt.Errorf("parsing address %q: %w", s, err)
+	}
+	if addr.Protocol() == address.Delegated {
+		// only f410 addresses, with a 20 byte subaddress, convert
+		if _, err := ethtypes.EthAddressFromFilecoinAddress(addr); err != nil {
+			return address.Undef, fmt.Errorf("unsupported delegated address %s, only f410 addresses are supported: %w", s, err)
+		}
+	}
+	return addr, nil
+}
+
+// EthAddress returns the 0x Ethereum address of an f410 or id address
+func EthAddress(addr address.Address) (string, error) {
+	ethAddr, err := ethtypes.EthAddressFromFilecoinAddress(addr)
+	if err != nil {
+		return "", fmt.Errorf("%s has no ethereum address: %w", addr, err)
+	}
+	return ethAddr.String(), nil
+}
+
+// parseEthAddress parses a 0x address of 20 bytes. Mixed case addresses must
+// have a valid EIP-55 checksum.
+func parseEthAddress(s string) (ethtypes.EthAddress, error) {
+	h := s[2:]
+	if len(h) != 2*ethtypes.EthAddressLength {
+		return ethtypes.EthAddress{}, fmt.Errorf("ethereum address %s must have %d hex digits, got %d", s, 2*ethtypes.EthAddressLength, len(h))
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return ethtypes.EthAddress{}, fmt.Errorf("parsing ethereum address %s: %w", s, err)
+	}
+	if h != strings.ToLower(h) && h != strings.ToUpper(h) && h != eip55(h) {
+		return ethtypes.EthAddress{}, fmt.Errorf("ethereum address %s has an invalid checksum", s)
+	}
+	return ethtypes.CastEthAddress(b)
+}
+
+// eip55 returns the hex digits of an address with the case of the EIP-55
+// checksum
+func eip55(h string) string {
+	lower := strings.ToLower(h)
+	k := sha3.NewLegacyKeccak256()
+	k.Write([]byte(lower)) //nolint:errcheck
+	sum := k.Sum(nil)
+
+	out := []byte(lower)
+	for i, c := range out {
+		nibble := sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = sum[i/2] & 0xf
+		}
+		if c >= 'a' && c <= 'f' && nibble >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return string(out)
+}
+
+// normalizeDealAddresses rewrites the provider and sender wallet of the deal
+// config as Filecoin addresses, for the lotus APIs that do not accept 0x
+// addresses
+func normalizeDealAddresses(dealConfig *model.DealConfig) error {
+	if dealConfig.MinerFid != "" {
+		maddr, err := ParseAddress(dealConfig.MinerFid)
+		if err != nil {
+			return fmt.Errorf("invalid provider: %w", err)
+		}
+		dealConfig.MinerFid = maddr.String()
+	}
+	if dealConfig.SenderWallet != "" {
+		walletAddr, err := ParseAddress(dealConfig.SenderWallet)
+		if err != nil {
+			return fmt.Errorf("invalid sender wallet: %w", err)
+		}
+		dealConfig.SenderWallet = walletAddr.String()
+	}
+	return nil
+}
@@ -0,0 +1,727 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/cmd/boost/util"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	verifreg13types "github.com/filecoin-project/go-state-types/builtin/v13/verifreg"
+	"github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultAllocationBatchSize is the number of allocation requests sent in a
+// single DataCap transfer message
+const DefaultAllocationBatchSize = 500
+
+// AllocationRequest is a piece for which DataCap should be allocated to a provider
+type AllocationRequest struct {
+	PieceCid   string
+	PieceSize  int64 // padded piece size
+	Miner      string
+	TermMin    abi.ChainEpoch
+	TermMax    abi.ChainEpoch // verifreg13types.MaximumVerifiedAllocationTerm if 0
+	Expiration abi.ChainEpoch // number of epochs after the current head the provider has to claim the allocation
+}
+
+// AllocationFailure reports a piece for which no allocation was made
+type AllocationFailure struct {
+	PieceCid string
+	Miner    string
+	Message  cid.Cid // the allocation message of the piece, cid.Undef if it was not sent
+	Error    string
+}
+
+// AllocationTerms are the terms of an allocation made by AllocateDeal
+type AllocationTerms struct {
+	TermMin    abi.ChainEpoch // minimum term the provider must store the piece, the deal duration by default
+	TermMax    abi.ChainEpoch // maximum term the provider may store the piece, verifreg13types.MaximumVerifiedAllocationTerm by default
+	Expiration abi.ChainEpoch // epoch by which the provider must claim the allocation, the deal start epoch by default
+}
+
+type AllocateOption func(*AllocationTerms)
+
+// WithTermMin sets the minimum term of the allocation
+func WithTermMin(termMin abi.ChainEpoch) AllocateOption {
+	return func(t *AllocationTerms) {
+		t.TermMin = termMin
+	}
+}
+
+// WithTermMax sets the maximum term of the allocation
+func WithTermMax(termMax abi.ChainEpoch) AllocateOption {
+	return func(t *AllocationTerms) {
+		t.TermMax = termMax
+	}
+}
+
+// WithExpiration sets the epoch by which the provider must claim the
+// allocation
+func WithExpiration(expiration abi.ChainEpoch) AllocateOption {
+	return func(t *AllocationTerms) {
+		t.Expiration = expiration
+	}
+}
+
+// validateAllocationTerms checks the terms against the verified registry
+// limits. expiration is relative to the current head.
+func validateAllocationTerms(termMin, termMax, expiration abi.ChainEpoch) error {
+	if termMin < verifreg13types.MinimumVerifiedAllocationTerm {
+		return fmt.Errorf("term min %d is shorter than the minimum verified allocation term %d", termMin, verifreg13types.MinimumVerifiedAllocationTerm)
+	}
+	if termMax > verifreg13types.MaximumVerifiedAllocationTerm {
+		return fmt.Errorf("term max %d is longer than the maximum verified allocation term %d", termMax, verifreg13types.MaximumVerifiedAllocationTerm)
+	}
+	if termMin > termMax {
+		return fmt.Errorf("term min %d is longer than term max %d", termMin, termMax)
+	}
+	if expiration <= 0 {
+		return fmt.Errorf("expiration must be after the current head, got %d epochs from now", expiration)
+	}
+	if expiration > verifreg13types.MaximumVerifiedAllocationExpiration {
+		return fmt.Errorf("expiration %d epochs from now is later than the maximum verified allocation expiration of %d epochs", expiration, verifreg13types.MaximumVerifiedAllocationExpiration)
+	}
+	return nil
+}
+
+type AllocationResult struct {
+	Allocations map[string]uint64 // allocation id by piece cid
+	IDs         []uint64          // allocation ids in the order of the requests, 0 for failed requests
+	Messages    []cid.Cid
+	Failures    []AllocationFailure
+}
+
+// AllocateDeals allocates DataCap from the wallet for many pieces at once. The
+// requests are packed into as few messages as the batch size allows. Pieces
+// that fail validation, whose message is not sent or fails on chain are
+// reported in the result failures, the other allocations are still made.
+func (client *Client) AllocateDeals(walletAddress string, reqs []AllocationRequest, batchSize int, assumeYes bool) (*AllocationResult, error) {
+	ctx := context.Background()
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.allocateDeals(ctx, gapi, n, walletAddr, reqs, batchSize, assumeYes)
+}
+
+// AllocationPlan is the outcome of an allocation dry-run
+type AllocationPlan struct {
+	Messages []*chaintypes.Message // unsigned messages with estimated gas
+	DataCap  abi.StoragePower      // DataCap the allocations would spend
+	MaxFee   abi.TokenAmount       // sum of the max fees of the messages
+	Failures []AllocationFailure   // requests that would not be allocated
+}
+
+// DryRunAllocateDeals builds the messages AllocateDeals would send and
+// estimates their gas, without signing or sending them
+func (client *Client) DryRunAllocateDeals(walletAddress string, reqs []AllocationRequest, batchSize int) (*AllocationPlan, error) {
+	ctx := context.Background()
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.dryRunAllocateDeals(ctx, gapi, walletAddr, reqs, batchSize)
+}
+
+func (client *Client) dryRunAllocateDeals(ctx context.Context, gapi api.Gateway, walletAddr address.Address, reqs []AllocationRequest, batchSize int) (*AllocationPlan, error) {
+	prep, err := prepareAllocations(ctx, gapi, walletAddr, reqs, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &AllocationPlan{
+		DataCap:  big.Zero(),
+		MaxFee:   big.Zero(),
+		Failures: prep.failures,
+	}
+	for _, info := range prep.infos {
+		plan.DataCap = big.Add(plan.DataCap, big.NewInt(info.Size))
+	}
+
+	plan.Messages, err = estimateMessages(ctx, gapi, prep.msgs, client.msgOpts)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range plan.Messages {
+		plan.MaxFee = big.Add(plan.MaxFee, big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit)))
+	}
+	return plan, nil
+}
+
+// preparedAllocations are the allocation messages for the valid requests
+type preparedAllocations struct {
+	batchSize int
+	infos     []util.PieceInfos
+	valid     []int // index of the request of each info
+	msgs      []*chaintypes.Message
+	failures  []AllocationFailure
+}
+
+func prepareAllocations(ctx context.Context, gapi api.Gateway, walletAddr address.Address, reqs []AllocationRequest, batchSize int) (*preparedAllocations, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultAllocationBatchSize
+	}
+	prep := &preparedAllocations{batchSize: batchSize}
+
+	// validate the requests up front, so that a single bad piece does not
+	// fail the whole batch
+	for i, req := range reqs {
+		info, err := pieceInfo(ctx, gapi, req)
+		if err != nil {
+			prep.failures = append(prep.failures, AllocationFailure{PieceCid: req.PieceCid, Miner: req.Miner, Error: err.Error()})
+			continue
+		}
+		prep.infos = append(prep.infos, *info)
+		prep.valid = append(prep.valid, i)
+	}
+
+	if len(prep.infos) == 0 {
+		if len(prep.failures) == 1 {
+			return prep, fmt.Errorf("invalid allocation request for piece %s: %s", prep.failures[0].PieceCid, prep.failures[0].Error)
+		}
+		return prep, fmt.Errorf("none of the %d allocation requests is valid", len(reqs))
+	}
+
+	msgs, err := util.CreateAllocationMsg(ctx, gapi, prep.infos, walletAddr, batchSize)
+	if err != nil {
+		return prep, err
+	}
+	prep.msgs = msgs
+	return prep, nil
+}
+
+func (client *Client) allocateDeals(ctx context.Context, gapi api.Gateway, n *clinode.Node, walletAddr address.Address, reqs []AllocationRequest, batchSize int, assumeYes bool) (*AllocationResult, error) {
+	res := &AllocationResult{
+		Allocations: make(map[string]uint64),
+		IDs:         make([]uint64, len(reqs)),
+	}
+
+	msig, err := isMultisig(ctx, gapi, walletAddr)
+	if err != nil {
+		return res, err
+	}
+	if msig {
+		return res, fmt.Errorf("wallet %s is a multisig, propose the allocation to it with MultisigAllocateDeals", walletAddr)
+	}
+
+	prep, err := prepareAllocations(ctx, gapi, walletAddr, reqs, batchSize)
+	res.Failures = prep.failures
+	if err != nil {
+		return res, err
+	}
+	infos, valid := prep.infos, prep.valid
+	batchSize = prep.batchSize
+
+	oldallocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return res, fmt.Errorf("failed to get allocations: %w", err)
+	}
+
+	// on a push failure the messages pushed before it are still processed
+	mcids, pushErr := client.pushMessages(ctx, gapi, n, prep.msgs, assumeYes)
+	res.Messages = mcids
+
+	// util.CreateAllocationMsg packs the requests into messages in order, so
+	// message i carries infos [i*batchSize, (i+1)*batchSize)
+	batchOf := func(i int) []int {
+		end := (i + 1) * batchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		var batch []int
+		for j := i * batchSize; j < end; j++ {
+			batch = append(batch, j)
+		}
+		return batch
+	}
+
+	fail := func(j int, mcid cid.Cid, reason string) {
+		req := reqs[valid[j]]
+		res.Failures = append(res.Failures, AllocationFailure{PieceCid: req.PieceCid, Miner: req.Miner, Message: mcid, Error: reason})
+	}
+
+	var landed []int
+	for i, mcid := range mcids {
+		if !mcid.Defined() {
+			for _, j := range batchOf(i) {
+				fail(j, mcid, "allocation message was not sent")
+			}
+			continue
+		}
+
+		if _, err := client.waitMessage(ctx, gapi, mcid); err != nil {
+			for _, j := range batchOf(i) {
+				fail(j, mcid, err.Error())
+			}
+			continue
+		}
+		landed = append(landed, batchOf(i)...)
+	}
+	for i := len(mcids); i < len(prep.msgs); i++ {
+		for _, j := range batchOf(i) {
+			fail(j, cid.Undef, fmt.Sprintf("allocation message was not sent: %s", pushErr))
+		}
+	}
+
+	newallocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return res, fmt.Errorf("failed to get allocations: %w", err)
+	}
+
+	// Generate a diff to find new allocations
+	for i := range newallocations {
+		if _, ok := oldallocations[i]; ok {
+			delete(newallocations, i)
+		}
+	}
+
+	printAllocation(newallocations, false)
+
+	for _, j := range landed {
+		aid, ok := takeAllocation(newallocations, infos[j].Cid, infos[j].Miner)
+		if !ok {
+			fail(j, cid.Undef, "allocation not found after the message landed on chain")
+			continue
+		}
+		r := valid[j]
+		res.IDs[r] = uint64(aid)
+		res.Allocations[reqs[r].PieceCid] = uint64(aid)
+		logs.GetLogger().Infof("data cap allocate success for piece %s, allocation id: %d", reqs[r].PieceCid, aid)
+	}
+	return res, pushErr
+}
+
+func pieceInfo(ctx context.Context, gapi api.Gateway, req AllocationRequest) (*util.PieceInfos, error) {
+	pieceCid, err := cid.Parse(req.PieceCid)
+	if err != nil {
+		return nil, fmt.Errorf("parsing piece cid %s: %w", req.PieceCid, err)
+	}
+
+	maddr, err := ParseAddress(req.Miner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse miner address %w", err)
+	}
+
+	idAddr, err := gapi.StateLookupID(ctx, maddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("looking up miner id of %s: %w", maddr, err)
+	}
+
+	mid, err := address.IDFromAddress(idAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert miner address %w", err)
+	}
+
+	minfo, err := gapi.StateMinerInfo(ctx, idAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("getting miner info of %s: %w", maddr, err)
+	}
+	if uint64(minfo.SectorSize) < uint64(req.PieceSize) {
+		return nil, fmt.Errorf("piece size %d is bigger than miner's sector size %s", req.PieceSize, minfo.SectorSize)
+	}
+
+	tmax := req.TermMax
+	if tmax == 0 {
+		tmax = verifreg13types.MaximumVerifiedAllocationTerm
+	}
+	if err := validateAllocationTerms(req.TermMin, tmax, req.Expiration); err != nil {
+		return nil, err
+	}
+
+	return &util.PieceInfos{
+		Cid:       pieceCid,
+		Size:      req.PieceSize,
+		Miner:     abi.ActorID(mid),
+		MinerAddr: idAddr,
+		Tmin:      req.TermMin,
+		Tmax:      tmax,
+		Exp:       req.Expiration,
+	}, nil
+}
+
+// takeAllocation returns the id of an allocation of the piece to the
+// provider and removes it from the map, so that the same allocation is not
+// matched twice when a piece is allocated more than once
+func takeAllocation(allocations map[verifreg.AllocationId]verifreg.Allocation, pieceCid cid.Cid, provider abi.ActorID) (verifreg.AllocationId, bool) {
+	for aid, allocation := range allocations {
+		if allocation.Data.Equals(pieceCid) && allocation.Provider == provider {
+			delete(allocations, aid)
+			return aid, true
+		}
+	}
+	return 0, false
+}
+
+// AllocationInfo is an allocation of DataCap made by the client to a provider
+type AllocationInfo struct {
+	ID         verifreg.AllocationId `json:"id"`
+	Client     abi.ActorID           `json:"client"`
+	Provider   abi.ActorID           `json:"provider"`
+	Data       cid.Cid               `json:"data"`
+	Size       abi.PaddedPieceSize   `json:"size"`
+	TermMin    abi.ChainEpoch        `json:"term_min"`
+	TermMax    abi.ChainEpoch        `json:"term_max"`
+	Expiration abi.ChainEpoch        `json:"expiration"`
+	Expired    bool                  `json:"expired"` // the provider can no longer claim the allocation
+}
+
+// ClaimInfo is an allocation of the client claimed by a provider, i.e. a
+// piece sealed into a sector
+type ClaimInfo struct {
+	ID        verifreg.ClaimId    `json:"id"`
+	Client    abi.ActorID         `json:"client"`
+	Provider  abi.ActorID         `json:"provider"`
+	Data      cid.Cid             `json:"data"`
+	Size      abi.PaddedPieceSize `json:"size"`
+	TermMin   abi.ChainEpoch      `json:"term_min"`
+	TermMax   abi.ChainEpoch      `json:"term_max"`
+	TermStart abi.ChainEpoch      `json:"term_start"`
+	Sector    abi.SectorNumber    `json:"sector"`
+	Expires   abi.ChainEpoch      `json:"expires"` // epoch the claim term ends, TermStart + TermMax
+}
+
+// AllocationMessages are the messages of an allocation management operation.
+// On a dry-run the messages are only built, and Sent is empty.
+type AllocationMessages struct {
+	Messages []*chaintypes.Message
+	Sent     []cid.Cid // aligned with Messages, cid.Undef for messages that were not sent
+}
+
+func allocationInfos(allocations map[verifreg.AllocationId]verifreg.Allocation, head abi.ChainEpoch) []AllocationInfo {
+	var infos []AllocationInfo
+	for id, allocation := range allocations {
+		infos = append(infos, AllocationInfo{
+			ID:         id,
+			Client:     allocation.Client,
+			Provider:   allocation.Provider,
+			Data:       allocation.Data,
+			Size:       allocation.Size,
+			TermMin:    allocation.TermMin,
+			TermMax:    allocation.TermMax,
+			Expiration: allocation.Expiration,
+			Expired:    head > 0 && allocation.Expiration < head,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// ListAllocations returns the unclaimed allocations made by the wallet
+func (client *Client) ListAllocations(walletAddress string) ([]AllocationInfo, error) {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocations: %w", err)
+	}
+	return allocationInfos(allocations, head.Height()), nil
+}
+
+// ListClaims returns the claims of the allocations made by the wallet. Claims
+// are stored per provider on chain, so the providers to look at have to be
+// given; if none are, the providers of the deals in the deal db are used.
+func (client *Client) ListClaims(walletAddress string, miners []string) ([]ClaimInfo, error) {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	return client.listClaims(ctx, gapi, walletAddr, miners)
+}
+
+func (client *Client) listClaims(ctx context.Context, gapi api.Gateway, walletAddr address.Address, miners []string) ([]ClaimInfo, error) {
+	clientID, err := actorID(ctx, gapi, walletAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(miners) == 0 {
+		miners, err = client.dealProviders(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var claims []ClaimInfo
+	for _, miner := range miners {
+		maddr, err := ParseAddress(miner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse miner address %w", err)
+		}
+		providerID, err := actorID(ctx, gapi, maddr)
+		if err != nil {
+			return nil, err
+		}
+
+		mclaims, err := gapi.StateGetClaims(ctx, maddr, chaintypes.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("getting claims for miner %s: %w", maddr, err)
+		}
+		for id, claim := range mclaims {
+			if claim.Client != clientID {
+				continue
+			}
+			claims = append(claims, ClaimInfo{
+				ID:        id,
+				Client:    claim.Client,
+				Provider:  providerID,
+				Data:      claim.Data,
+				Size:      claim.Size,
+				TermMin:   claim.TermMin,
+				TermMax:   claim.TermMax,
+				TermStart: claim.TermStart,
+				Sector:    claim.Sector,
+				Expires:   claim.TermStart + claim.TermMax,
+			})
+		}
+	}
+
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Provider != claims[j].Provider {
+			return claims[i].Provider < claims[j].Provider
+		}
+		return claims[i].ID < claims[j].ID
+	})
+	return claims, nil
+}
+
+// dealProviders returns the distinct providers of the deals in the deal db
+func (client *Client) dealProviders(ctx context.Context) ([]string, error) {
+	dealDB, err := client.DealDB()
+	if err != nil {
+		return nil, fmt.Errorf("opening deal db: %w", err)
+	}
+	defer dealDB.Close()
+
+	deals, err := dealDB.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	var miners []string
+	for _, deal := range deals {
+		if _, ok := seen[deal.Provider]; ok {
+			continue
+		}
+		seen[deal.Provider] = struct{}{}
+		miners = append(miners, deal.Provider)
+	}
+	return miners, nil
+}
+
+// RemoveExpiredAllocations removes the allocations of the wallet that were
+// not claimed before their expiration, which returns their DataCap to the
+// wallet. With dryRun set the messages are returned without being sent.
+func (client *Client) RemoveExpiredAllocations(walletAddress string, dryRun, assumeYes bool) (*AllocationMessages, error) {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocations: %w", err)
+	}
+
+	var expired []verifreg13types.AllocationId
+	for _, info := range allocationInfos(allocations, head.Height()) {
+		if info.Expired {
+			expired = append(expired, verifreg13types.AllocationId(info.ID))
+		}
+	}
+
+	res := &AllocationMessages{}
+	if len(expired) == 0 {
+		logs.GetLogger().Info("no expired allocations for wallet ", walletAddr)
+		return res, nil
+	}
+
+	clientID, err := actorID(ctx, gapi, walletAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(expired); i += DefaultAllocationBatchSize {
+		end := i + DefaultAllocationBatchSize
+		if end > len(expired) {
+			end = len(expired)
+		}
+
+		params, err := actors.SerializeParams(&verifreg13types.RemoveExpiredAllocationsParams{
+			Client:        clientID,
+			AllocationIds: expired[i:end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("serializing remove expired allocations params: %w", err)
+		}
+		res.Messages = append(res.Messages, &chaintypes.Message{
+			To:     builtin.VerifiedRegistryActorAddr,
+			From:   walletAddr,
+			Value:  big.Zero(),
+			Method: builtin.MethodsVerifiedRegistry.RemoveExpiredAllocations,
+			Params: params,
+		})
+	}
+	logs.GetLogger().Info("removing ", len(expired), " expired allocations in ", len(res.Messages), " message[s]")
+
+	return res, client.sendAllocationMessages(ctx, gapi, res, dryRun, assumeYes)
+}
+
+// ExtendClaims extends the term of the wallet's claims that end within the
+// given number of epochs to termMax, or to the maximum verified allocation
+// term if termMax is 0. With dryRun set the messages are returned without
+// being sent.
+func (client *Client) ExtendClaims(walletAddress string, miners []string, within, termMax abi.ChainEpoch, dryRun, assumeYes bool) (*AllocationMessages, error) {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if termMax == 0 {
+		termMax = verifreg13types.MaximumVerifiedAllocationTerm
+	}
+	if termMax > verifreg13types.MaximumVerifiedAllocationTerm {
+		return nil, fmt.Errorf("term max %d is bigger than the maximum verified allocation term %d", termMax, verifreg13types.MaximumVerifiedAllocationTerm)
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := client.listClaims(ctx, gapi, walletAddr, miners)
+	if err != nil {
+		return nil, err
+	}
+
+	pcm := make(map[verifreg13types.ClaimId]util.ProvInfo)
+	for _, claim := range claims {
+		if claim.Expires <= head.Height() || claim.Expires > head.Height()+within || claim.TermMax >= termMax {
+			continue
+		}
+		provAddr, err := address.NewIDAddress(uint64(claim.Provider))
+		if err != nil {
+			return nil, err
+		}
+		pcm[verifreg13types.ClaimId(claim.ID)] = util.ProvInfo{Addr: provAddr, ID: claim.Provider}
+	}
+
+	res := &AllocationMessages{}
+	if len(pcm) == 0 {
+		logs.GetLogger().Info("no claims of wallet ", walletAddr, " to extend")
+		return res, nil
+	}
+
+	res.Messages, err = util.CreateExtendClaimMsg(ctx, gapi, pcm, nil, walletAddr, termMax, false, assumeYes || dryRun, DefaultAllocationBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	logs.GetLogger().Info("extending ", len(pcm), " claims to term max ", termMax, " in ", len(res.Messages), " message[s]")
+
+	return res, client.sendAllocationMessages(ctx, gapi, res, dryRun, assumeYes)
+}
+
+func (client *Client) sendAllocationMessages(ctx context.Context, gapi api.Gateway, res *AllocationMessages, dryRun, assumeYes bool) error {
+	if dryRun {
+		for _, msg := range res.Messages {
+			logs.GetLogger().Info("dry-run, not sending message from ", msg.From, " to ", msg.To, " method ", msg.Method, ", params ", len(msg.Params), " bytes")
+		}
+		return nil
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return err
+	}
+	defer n.Host.Close()
+
+	res.Sent, err = client.pushMessages(ctx, gapi, n, res.Messages, assumeYes)
+	if err != nil {
+		return err
+	}
+	return client.waitMessages(ctx, gapi, res.Sent)
+}
+
+func actorID(ctx context.Context, gapi api.Gateway, addr address.Address) (abi.ActorID, error) {
+	idAddr, err := gapi.StateLookupID(ctx, addr, chaintypes.EmptyTSK)
+	if err != nil {
+		return 0, fmt.Errorf("looking up actor id of %s: %w", addr, err)
+	}
+	id, err := address.IDFromAddress(idAddr)
+	if err != nil {
+		return 0, err
+	}
+	return abi.ActorID(id), nil
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/mitchellh/go-homedir"
+)
+
+const AskCacheDBName = "askcache.db"
+
+var createAskCacheDBSQL = `
+CREATE TABLE IF NOT EXISTS AskCache (
+          Miner         TEXT PRIMARY KEY,
+          Price         TEXT,
+          VerifiedPrice TEXT,
+          MinPieceSize  INT,
+          MaxPieceSize  INT,
+          LatencyMs     INT,
+          Error         TEXT,
+          QueriedAt     DateTime
+);
+`
+
+// AskCacheDB caches the results of ask queries in the client repo
+type AskCacheDB struct {
+	db *sql.DB
+}
+
+func NewAskCacheDB(repo string) (*AskCacheDB, error) {
+	repoPath, err := homedir.Expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := path.Join(repoPath, AskCacheDBName)
+	d, err := db.SqlDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.ExecContext(context.TODO(), createAskCacheDBSQL); err != nil {
+		d.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to create tables in ask cache DB: %w", err)
+	}
+	return &AskCacheDB{db: d}, nil
+}
+
+func (a *AskCacheDB) Close() error {
+	return a.db.Close()
+}
+
+// Put stores the result of an ask query, replacing the previous result for the miner
+func (a *AskCacheDB) Put(ctx context.Context, res *AskCrawlResult) error {
+	qry := "INSERT OR REPLACE INTO AskCache (Miner, Price, VerifiedPrice, MinPieceSize, MaxPieceSize, LatencyMs, Error, QueriedAt) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	values := []interface{}{res.Miner, tokenString(res.Price), tokenString(res.VerifiedPrice), res.MinPieceSize, res.MaxPieceSize,
+		res.Latency.Milliseconds(), res.Error, res.QueriedAt}
+	_, err := a.db.ExecContext(ctx, qry, values...)
+	return err
+}
+
+// Get returns the cached ask result for the miner if it was queried less than ttl ago
+func (a *AskCacheDB) Get(ctx context.Context, miner string, ttl time.Duration) (*AskCrawlResult, bool, error) {
+	var price, verifiedPrice string
+	var latencyMs int64
+	res := &AskCrawlResult{Miner: miner}
+	qry := "SELECT Price, VerifiedPrice, MinPieceSize, MaxPieceSize, LatencyMs, Error, QueriedAt FROM AskCache WHERE Miner=?"
+	row := a.db.QueryRowContext(ctx, qry, miner)
+	err := row.Scan(&price, &verifiedPrice, &res.MinPieceSize, &res.MaxPieceSize, &latencyMs, &res.Error, &res.QueriedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+
+	if time.Since(res.QueriedAt) > ttl {
+		return nil, false, nil
+	}
+
+	if res.Price, err = parseToken(price); err != nil {
+		return nil, false, err
+	}
+	if res.VerifiedPrice, err = parseToken(verifiedPrice); err != nil {
+		return nil, false, err
+	}
+	res.Latency = time.Duration(latencyMs) * time.Millisecond
+	res.Cached = true
+	return res, true, nil
+}
+
+func tokenString(t abi.TokenAmount) string {
+	if t.Nil() {
+		return ""
+	}
+	return t.String()
+}
+
+func parseToken(s string) (abi.TokenAmount, error) {
+	if s == "" {
+		return abi.TokenAmount{}, nil
+	}
+	return big.FromString(s)
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+const (
+	DefaultAskCrawlConcurrency = 16
+	DefaultAskCrawlTimeout     = 30 * time.Second
+)
+
+// Columns the ask crawl results can be sorted by
+const (
+	AskSortMiner         = "miner"
+	AskSortPrice         = "price"
+	AskSortVerifiedPrice = "verified-price"
+	AskSortMinPieceSize  = "min-piece-size"
+	AskSortMaxPieceSize  = "max-piece-size"
+	AskSortLatency       = "latency"
+)
+
+type AskCrawlOptions struct {
+	Concurrency int           // number of miners queried at the same time. default 16
+	Timeout     time.Duration // timeout of the query to a single miner. default 30s
+	CacheTTL    time.Duration // reuse results cached in the client repo for this long; 0 disables the cache
+}
+
+// AskCrawlResult is the storage ask of a single miner, or the error querying it
+type AskCrawlResult struct {
+	Miner         string
+	Price         abi.TokenAmount // attoFIL per epoch per GiB
+	VerifiedPrice abi.TokenAmount // attoFIL per epoch per GiB
+	MinPieceSize  abi.PaddedPieceSize
+	MaxPieceSize  abi.PaddedPieceSize
+	Latency       time.Duration
+	Error         string
+	QueriedAt     time.Time
+	Cached        bool
+}
+
+// CrawlAsks queries the storage asks of the given miners concurrently, over a
+// single libp2p host. If no miners are given, every miner with the minimum
+// power on chain is queried. Results are reported by normalized address.
+func (client *Client) CrawlAsks(ctx context.Context, miners []string, opts AskCrawlOptions) ([]*AskCrawlResult, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultAskCrawlConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultAskCrawlTimeout
+	}
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return nil, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	checkPower := false
+	if len(miners) == 0 {
+		addrs, err := fullNode.StateListMiners(ctx, chaintypes.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("listing miners: %w", err)
+		}
+		for _, addr := range addrs {
+			miners = append(miners, addr.String())
+		}
+		checkPower = true
+	}
+
+	var cache *AskCacheDB
+	if opts.CacheTTL > 0 {
+		cache, err = NewAskCacheDB(client.ClientRepo)
+		if err != nil {
+			return nil, fmt.Errorf("opening ask cache db: %w", err)
+		}
+		defer cache.Close()
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	// the same miner can be given as f0, t0 or 0x address; the results, the
+	// ask cache and the deal history of scores use the normalized address
+	var results []*AskCrawlResult
+	var maddrs []address.Address
+	seen := make(map[address.Address]bool)
+	for _, miner := range miners {
+		maddr, err := ParseAddress(miner)
+		if err != nil {
+			results = append(results, &AskCrawlResult{Miner: miner, Error: err.Error(), QueriedAt: time.Now()})
+			continue
+		}
+		if !seen[maddr] {
+			seen[maddr] = true
+			maddrs = append(maddrs, maddr)
+		}
+	}
+
+	var lk sync.Mutex
+	var wg sync.WaitGroup
+	throttle := make(chan struct{}, opts.Concurrency)
+	for _, maddr := range maddrs {
+		select {
+		case throttle <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return results, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(maddr address.Address) {
+			defer wg.Done()
+			defer func() { <-throttle }()
+
+			res := client.crawlAsk(ctx, n.Host, fullNode, cache, maddr, checkPower, opts)
+			if res == nil {
+				return
+			}
+			lk.Lock()
+			results = append(results, res)
+			lk.Unlock()
+		}(maddr)
+	}
+	wg.Wait()
+
+	SortAskResults(results, AskSortMiner)
+	return results, nil
+}
+
+// crawlAsk queries the ask of a single miner. It returns nil if checkPower is
+// set and the miner does not have the minimum power.
+func (client *Client) crawlAsk(ctx context.Context, h host.Host, fullNode api.FullNode, cache *AskCacheDB, maddr address.Address, checkPower bool, opts AskCrawlOptions) *AskCrawlResult {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	miner := maddr.String()
+
+	if cache != nil {
+		res, ok, err := cache.Get(ctx, miner, opts.CacheTTL)
+		if err != nil {
+			logs.GetLogger().Warn("miner: ", miner, ", reading cached ask failed: ", err)
+		}
+		if ok {
+			return res
+		}
+	}
+
+	if checkPower {
+		power, err := fullNode.StateMinerPower(ctx, maddr, chaintypes.EmptyTSK)
+		if err != nil || !power.HasMinPower {
+			return nil
+		}
+	}
+
+	res := &AskCrawlResult{Miner: miner, QueriedAt: time.Now()}
+	ask, err := queryAsk(ctx, h, fullNode, maddr)
+	res.Latency = time.Since(res.QueriedAt)
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Price = ask.Price
+		res.VerifiedPrice = ask.VerifiedPrice
+		res.MinPieceSize = ask.MinPieceSize
+		res.MaxPieceSize = ask.MaxPieceSize
+	}
+
+	if cache != nil {
+		if err := cache.Put(context.Background(), res); err != nil {
+			logs.GetLogger().Warn("miner: ", miner, ", caching ask failed: ", err)
+		}
+	}
+	return res
+}
+
+// SortAskResults sorts the results by the given column, ascending. Results
+// with an error are always sorted last.
+func SortAskResults(results []*AskCrawlResult, by string) {
+	less := func(a, b *AskCrawlResult) bool { return a.Miner < b.Miner }
+	switch by {
+	case AskSortPrice:
+		less = func(a, b *AskCrawlResult) bool { return big.Cmp(a.Price, b.Price) < 0 }
+	case AskSortVerifiedPrice:
+		less = func(a, b *AskCrawlResult) bool { return big.Cmp(a.VerifiedPrice, b.VerifiedPrice) < 0 }
+	case AskSortMinPieceSize:
+		less = func(a, b *AskCrawlResult) bool { return a.MinPieceSize < b.MinPieceSize }
+	case AskSortMaxPieceSize:
+		less = func(a, b *AskCrawlResult) bool { return a.MaxPieceSize < b.MaxPieceSize }
+	case AskSortLatency:
+		less = func(a, b *AskCrawlResult) bool { return a.Latency < b.Latency }
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if (a.Error == "") != (b.Error == "") {
+			return a.Error == ""
+		}
+		if a.Error != "" {
+			return a.Miner < b.Miner
+		}
+		return less(a, b)
+	})
+}
+
+// PrintAskResults writes the results as a table
+func PrintAskResults(results []*AskCrawlResult, w io.Writer) error {
+	minerKey := "Miner"
+	priceKey := "Price/GiB/Epoch"
+	verifiedPriceKey := "Verified Price/GiB/Epoch"
+	minPieceSizeKey := "Min Piece Size"
+	maxPieceSizeKey := "Max Piece Size"
+	latencyKey := "Latency"
+	errorKey := "Error"
+
+	tw := tablewriter.New(
+		tablewriter.Col(minerKey),
+		tablewriter.Col(priceKey),
+		tablewriter.Col(verifiedPriceKey),
+		tablewriter.Col(minPieceSizeKey),
+		tablewriter.Col(maxPieceSizeKey),
+		tablewriter.Col(latencyKey),
+		tablewriter.NewLineCol(errorKey))
+	for _, res := range results {
+		row := map[string]interface{}{
+			minerKey:   res.Miner,
+			latencyKey: res.Latency.Round(time.Millisecond),
+		}
+		if res.Error != "" {
+			row[errorKey] = res.Error
+		} else {
+			row[priceKey] = chaintypes.FIL(res.Price)
+			row[verifiedPriceKey] = chaintypes.FIL(res.VerifiedPrice)
+			row[minPieceSizeKey] = chaintypes.SizeStr(chaintypes.NewInt(uint64(res.MinPieceSize)))
+			row[maxPieceSizeKey] = chaintypes.SizeStr(chaintypes.NewInt(uint64(res.MaxPieceSize)))
+		}
+		tw.Write(row)
+	}
+	return tw.Flush(w)
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/boost/storagemarket/types"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ipfs/go-cid"
+)
+
+// DealSendBuffer is the number of epochs before the start epoch by which a
+// deal has to be sent, so that the provider has time to seal it. Boost rejects
+// deals that start within its sealing buffer, 480 epochs by default.
+const DealSendBuffer = abi.ChainEpoch(480)
+
+// DealBundle is a deal proposal built on a networked host, signed on a host
+// holding the client wallet, and sent to the provider later. The deal params
+// are CBOR encoded in the JSON of the bundle, as the JSON encoding of a deal
+// label drops bytes labels.
+type DealBundle struct {
+	Params        types.DealParams `json:"params"`
+	ProposalCid   cid.Cid          `json:"proposal_cid"`   // cid of the proposal when the bundle was built
+	Label         string           `json:"label"`          // label as stored in the deal db
+	ProposedEpoch abi.ChainEpoch   `json:"proposed_epoch"` // chain head when the bundle was built
+	Deadline      abi.ChainEpoch   `json:"deadline"`       // last epoch the bundle can be sent at
+	DeadlineTime  time.Time        `json:"deadline_time"`  // estimated time of the deadline, for hosts without a chain
+}
+
+// Signed reports whether the deal proposal of the bundle has been signed
+func (b *DealBundle) Signed() bool {
+	return len(b.Params.ClientDealProposal.ClientSignature.Data) > 0
+}
+
+// MarshalJSON encodes the bundle with its deal params as CBOR
+func (b DealBundle) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.Params.MarshalCBOR(&buf); err != nil {
+		return nil, fmt.Errorf("encoding deal params: %w", err)
+	}
+	type bundle DealBundle
+	return json.Marshal(struct {
+		bundle
+		Params []byte `json:"params"`
+	}{bundle(b), buf.Bytes()})
+}
+
+// UnmarshalJSON decodes a bundle encoded by MarshalJSON
+func (b *DealBundle) UnmarshalJSON(data []byte) error {
+	type bundle DealBundle
+	aux := struct {
+		*bundle
+		Params []byte `json:"params"`
+	}{bundle: (*bundle)(b)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	b.Params = types.DealParams{}
+	if err := b.Params.UnmarshalCBOR(bytes.NewReader(aux.Params)); err != nil {
+		return fmt.Errorf("decoding deal params: %w", err)
+	}
+	return nil
+}
+
+// checkProposal checks that the deal proposal is still the one the bundle
+// was built with
+func (b *DealBundle) checkProposal() error {
+	if !b.ProposalCid.Defined() {
+		return fmt.Errorf("dealUuid: %s, the bundle has no proposal cid, prepare a new one", b.Params.DealUUID)
+	}
+	proposalCid, err := b.Params.ClientDealProposal.Proposal.Cid()
+	if err != nil {
+		return fmt.Errorf("dealUuid: %s, getting proposal cid: %w", b.Params.DealUUID, err)
+	}
+	if proposalCid != b.ProposalCid {
+		return fmt.Errorf("dealUuid: %s, the proposal cid %s differs from the cid %s the bundle was built with", b.Params.DealUUID, proposalCid, b.ProposalCid)
+	}
+	return nil
+}
+
+// PrepareDealBundle builds the unsigned deal proposal of the deal. The wallet
+// of the deal has to be given, as it is not in the keystore of this host.
+func (client *Client) PrepareDealBundle(dealP DealParam) (*DealBundle, error) {
+	ctx := context.Background()
+	if dealP.Wallet == "" {
+		return nil, fmt.Errorf("the wallet of the deal is required to prepare a deal bundle")
+	}
+	walletAddr, err := ParseAddress(dealP.Wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return nil, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	return client.prepareDeal(ctx, fullNode, walletAddr, dealP)
+}
+
+// SignDealBundle signs the deal proposal of the bundle with the wallet in the
+// keystore of the client repo, or with the signer of the client if set. It
+// does not need a chain connection; the deadline is checked against the
+// estimated deadline time.
+func (client *Client) SignDealBundle(b *DealBundle) error {
+	ctx := context.Background()
+	if time.Now().After(b.DeadlineTime) {
+		return fmt.Errorf("dealUuid: %s, the bundle expired at %s, prepare a new one", b.Params.DealUUID, b.DeadlineTime)
+	}
+	if err := b.checkProposal(); err != nil {
+		return err
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return err
+	}
+	defer n.Host.Close()
+
+	wallet := b.Params.ClientDealProposal.Proposal.Client
+	signer := client.nodeSigner(n)
+	has, err := signer.WalletHas(ctx, wallet)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("dealUuid: %s, wallet %s has no key to sign with", b.Params.DealUUID, wallet)
+	}
+
+	return signDealProposal(ctx, signer, &b.Params.ClientDealProposal)
+}
+
+// SendDealBundle checks the signature and deadline of a signed bundle and
+// sends its deal proposal to the provider
+func (client *Client) SendDealBundle(b *DealBundle) (string, error) {
+	ctx := context.Background()
+	dealUuid := b.Params.DealUUID
+	if !b.Signed() {
+		return "", fmt.Errorf("dealUuid: %s, the bundle is not signed", dealUuid)
+	}
+
+	if err := b.checkProposal(); err != nil {
+		return "", err
+	}
+
+	proposal := &b.Params.ClientDealProposal
+	buf, err := cborutil.Dump(&proposal.Proposal)
+	if err != nil {
+		return "", err
+	}
+	if err := sigs.Verify(&proposal.ClientSignature, proposal.Proposal.Client, buf); err != nil {
+		return "", fmt.Errorf("dealUuid: %s, invalid signature of the deal proposal: %w", dealUuid, err)
+	}
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return "", fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	head, err := fullNode.ChainHead(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot get chain head: %w", err)
+	}
+	if head.Height() > b.Deadline {
+		return "", fmt.Errorf("dealUuid: %s, the bundle expired at epoch %d, the chain head is %d and the deal starts at %d",
+			dealUuid, b.Deadline, head.Height(), proposal.Proposal.StartEpoch)
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return "", err
+	}
+	defer n.Host.Close()
+
+	return client.proposeDeal(ctx, n, fullNode, b)
+}
+
+// WriteDealBundle writes the bundle as JSON
+func (b *DealBundle) WriteDealBundle(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ReadDealBundle reads a bundle written by WriteDealBundle
+func ReadDealBundle(path string) (*DealBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b DealBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parsing deal bundle %s: %w", path, err)
+	}
+	return &b, nil
+}
@@ -1,25 +1,22 @@
 package client
 
 import (
-	"bufio"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	mbig "math/big"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	clinode "github.com/filecoin-project/boost/cli/node"
 	cliutil "github.com/filecoin-project/boost/cli/util"
 	"github.com/filecoin-project/boost/cmd"
-	"github.com/filecoin-project/boost/cmd/boost/util"
-	"github.com/filecoin-project/boost/cmd/lib"
 	"github.com/filecoin-project/boost/storagemarket/types"
 	"github.com/filecoin-project/boost/storagemarket/types/legacytypes"
 	"github.com/filecoin-project/boost/storagemarket/types/legacytypes/network"
@@ -43,24 +40,32 @@ import (
 	"github.com/filswan/go-swan-lib/utils"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-datastore"
-	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/host"
 	inet "github.com/libp2p/go-libp2p/core/network"
 	"github.com/mitchellh/go-homedir"
 	"github.com/shopspring/decimal"
-	"github.com/urfave/cli/v2"
-	"golang.org/x/sync/errgroup"
 )
 
 const (
-	DealProtocolv120 = "/fil/storage/mk/1.2.0"
-	AskProtocolID    = "/fil/storage/ask/1.1.0"
+	DealProtocolv120       = "/fil/storage/mk/1.2.0"
+	DealStatusProtocolv120 = "/fil/storage/status/1.2.0"
+	AskProtocolID          = "/fil/storage/ask/1.1.0"
 )
 
+// ErrDealRejected is returned when the storage provider does not accept a deal proposal
+var ErrDealRejected = errors.New("deal proposal rejected")
+
 type Client struct {
-	lotus       *lotus.LotusClient
-	FullNodeApi string
-	ClientRepo  string
+	lotus            *lotus.LotusClient
+	FullNodeApi      string
+	ClientRepo       string
+	autoTopUpEscrow  bool
+	msgOpts          MessageOptions
+	confirmFunc      ConfirmFunc
+	collateralPolicy CollateralPolicy
+	startPlanner     *StartEpochPlanner
+	keystoreKey      []byte // key of the encrypted keystore, nil while it is locked
+	signer           Signer // signs instead of the wallet of the client repo if set
 }
 
 func (client *Client) WithUrl(fullNodeApi string) (*Client, error) {
@@ -137,9 +142,17 @@ func (client *Client) InitRepo(repoPath, walletAddress string) error {
 	return nil
 }
 
+// ValidateExistWalletAddress reports whether the wallet, given as a Filecoin
+// or 0x address, is in the client wallet
 func (client *Client) ValidateExistWalletAddress(walletAddress string) bool {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		logs.GetLogger().Error("invalid wallet address: ", err)
+		return false
+	}
+
+	n, err := client.setupNode()
 	if err != nil {
 		logs.GetLogger().Error("setup node failed: %w", err)
 		return false
@@ -152,7 +165,8 @@ func (client *Client) ValidateExistWalletAddress(walletAddress string) bool {
 	}
 
 	for _, addr := range addressList {
-		if strings.EqualFold(addr.String()[1:], walletAddress[1:]) {
+		// addresses compare equal whatever their network prefix
+		if addr == walletAddr {
 			return true
 		}
 	}
@@ -161,7 +175,7 @@ func (client *Client) ValidateExistWalletAddress(walletAddress string) bool {
 
 func (client *Client) WalletImport(inputData []byte) error {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		logs.GetLogger().Error("setup node failed: %w", err)
 		return err
@@ -189,7 +203,7 @@ func (client *Client) WalletImport(inputData []byte) error {
 func (client *Client) WalletNew(walletType string) error {
 	ctx := context.Background()
 
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		return err
 	}
@@ -213,7 +227,7 @@ func (client *Client) WalletNew(walletType string) error {
 
 func (client *Client) WalletList() error {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		logs.GetLogger().Error("setup node failed: %w", err)
 		return err
@@ -317,19 +331,19 @@ func (client *Client) WalletList() error {
 	return tw.Flush(os.Stdout)
 }
 
+// WalletExport prints the key of the wallet as hex. Keys of an encrypted
+// keystore can only be exported encrypted, with WalletExportEncrypted.
 func (client *Client) WalletExport(walletAddress string) error {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	encrypted, err := client.KeystoreEncrypted()
 	if err != nil {
-		logs.GetLogger().Error("setup node failed: %w", err)
 		return err
 	}
-
-	addr, err := address.NewFromString(walletAddress)
-	if err != nil {
-		return err
+	if encrypted {
+		return errors.New("the keystore is encrypted, export the wallet encrypted to a recipient key")
 	}
-	ki, err := n.Wallet.WalletExport(ctx, addr)
+
+	ki, err := client.walletExport(ctx, walletAddress)
 	if err != nil {
 		return err
 	}
@@ -344,13 +358,13 @@ func (client *Client) WalletExport(walletAddress string) error {
 
 func (client *Client) WalletDelete(walletAddress string) error {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		logs.GetLogger().Error("setup node failed: %w", err)
 		return err
 	}
 
-	addr, err := address.NewFromString(walletAddress)
+	addr, err := ParseAddress(walletAddress)
 	if err != nil {
 		return err
 	}
@@ -358,148 +372,103 @@ func (client *Client) WalletDelete(walletAddress string) error {
 	return n.Wallet.WalletDelete(ctx, addr)
 }
 
-func (client *Client) AllocateDeal(dealConfig *model.DealConfig) (id uint64, err error) {
-	pieceSize, _ := utils.CalculatePieceSize(dealConfig.FileSize, true)
+// AllocateDeal allocates DataCap from the sender wallet for the piece of the
+// deal. The allocation terms default to the deal duration, the maximum
+// verified allocation term and the deal start epoch, and can be set with
+// options; they are validated against the verified registry limits.
+func (client *Client) AllocateDeal(dealConfig *model.DealConfig, opts ...AllocateOption) (id uint64, err error) {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		return
 	}
-	apiInfo := cliutil.ParseApiInfo(client.FullNodeApi)
-	addr, err := apiInfo.DialArgs("v1")
-	if err != nil {
-		return 0, fmt.Errorf("parse fullNodeApi failed: %w", err)
-	}
-	gapi, closer, err := apiclient.NewGatewayRPCV1(ctx, addr, apiInfo.AuthHeader())
+	defer n.Host.Close()
+
+	gapi, closer, err := client.getGatewayApi(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("can't setup gateway connection: %w", err)
+		return 0, err
 	}
 	defer closer()
 
-	walletAddr, err := address.NewFromString(dealConfig.SenderWallet)
+	walletAddr, req, err := dealAllocationRequest(ctx, gapi, dealConfig, opts)
 	if err != nil {
-		return
+		return 0, err
 	}
-	logs.GetLogger().Debug("selected wallet", "wallet", walletAddr)
 
-	head, err := gapi.ChainHead(ctx)
+	res, err := client.allocateDeals(ctx, gapi, n, walletAddr, []AllocationRequest{req}, 1, dealConfig.SkipConfirmation)
 	if err != nil {
-		return
+		return 0, err
 	}
-	exp := abi.ChainEpoch(dealConfig.StartEpoch) - head.Height()
 
-	pieceCid, err := cid.Parse(dealConfig.PieceCid)
-	if err != nil {
-		return
+	if len(res.Failures) > 0 {
+		return 0, fmt.Errorf("data cap allocation for piece %s failed: %s", dealConfig.PieceCid, res.Failures[0].Error)
 	}
+	return res.IDs[0], nil
+}
 
-	maddr, err := address.NewFromString(dealConfig.MinerFid)
+// DryRunAllocateDeal builds the message AllocateDeal would send and estimates
+// its gas, without signing or sending it
+func (client *Client) DryRunAllocateDeal(dealConfig *model.DealConfig, opts ...AllocateOption) (*AllocationPlan, error) {
+	ctx := context.Background()
+	gapi, closer, err := client.getGatewayApi(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse miner address %w", err)
+		return nil, err
 	}
+	defer closer()
 
-	mid, err := address.IDFromAddress(maddr)
+	walletAddr, req, err := dealAllocationRequest(ctx, gapi, dealConfig, opts)
 	if err != nil {
-		return 0, fmt.Errorf("failed to convert miner address %w", err)
+		return nil, err
 	}
+	return client.dryRunAllocateDeals(ctx, gapi, walletAddr, []AllocationRequest{req}, 1)
+}
 
-	infos := []util.PieceInfos{
-		{
-			Cid:       pieceCid,
-			Size:      pieceSize,
-			Miner:     abi.ActorID(mid),
-			MinerAddr: maddr,
-			Tmin:      abi.ChainEpoch(dealConfig.Duration),
-			Tmax:      verifreg13types.MaximumVerifiedAllocationTerm,
-			Exp:       abi.ChainEpoch(exp),
-		},
+func dealAllocationRequest(ctx context.Context, gapi api.Gateway, dealConfig *model.DealConfig, opts []AllocateOption) (address.Address, AllocationRequest, error) {
+	terms := AllocationTerms{
+		TermMin:    abi.ChainEpoch(dealConfig.Duration),
+		TermMax:    verifreg13types.MaximumVerifiedAllocationTerm,
+		Expiration: abi.ChainEpoch(dealConfig.StartEpoch),
 	}
-
-	msgs, err := util.CreateAllocationMsg(ctx, gapi, infos, walletAddr, len(infos))
-	if err != nil {
-		return
+	for _, opt := range opts {
+		opt(&terms)
 	}
 
-	oldallocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
+	walletAddr, err := ParseAddress(dealConfig.SenderWallet)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get allocations: %w", err)
+		return address.Undef, AllocationRequest{}, err
 	}
+	logs.GetLogger().Debug("selected wallet", "wallet", walletAddr)
 
-	flagSet := new(flag.FlagSet)
-	flagSet.BoolVar(new(bool), "assume-yes", dealConfig.SkipConfirmation, "")
-	cctx := cli.NewContext(nil, flagSet, nil)
-
-	var mcids []cid.Cid
-
-	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
-	for _, msg := range msgs {
-		mcid, sent, err := lib.SignAndPushToMpool(cctx, ctx, gapi, n, ds, msg)
-		if err != nil {
-			return 0, err
-		}
-		if !sent {
-			fmt.Printf("message %s with method %s not sent\n", msg.Cid(), msg.Method.String())
-			continue
-		}
-		mcids = append(mcids, mcid)
-	}
-
-	var mcidStr []string
-	for _, c := range mcids {
-		mcidStr = append(mcidStr, c.String())
-	}
-
-	logs.GetLogger().Info("submitted data cap allocation message[s]", mcidStr)
-	logs.GetLogger().Info("waiting for message to be included in a block")
-
-	// wait for msgs to get mined into a block
-	eg := errgroup.Group{}
-	eg.SetLimit(10)
-	for _, msg := range mcids {
-		m := msg
-		eg.Go(func() error {
-			wait, err := gapi.StateWaitMsg(ctx, m, 1, 2000, true)
-			if err != nil {
-				return fmt.Errorf("timeout waiting for message to land on chain %s", wait.Message)
-
-			}
-
-			if wait.Receipt.ExitCode.IsError() {
-				return fmt.Errorf("failed to execute message %s: %w", wait.Message, wait.Receipt.ExitCode)
-			}
-			return nil
-		})
-	}
-	err = eg.Wait()
+	head, err := gapi.ChainHead(ctx)
 	if err != nil {
-		return 0, err
+		return address.Undef, AllocationRequest{}, err
 	}
-
-	newallocations, err := gapi.StateGetAllocations(ctx, walletAddr, chaintypes.EmptyTSK)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get allocations: %w", err)
+	// the allocation expiration is absolute on chain, but relative to the head
+	// in the allocation request
+	if terms.Expiration <= head.Height() {
+		return address.Undef, AllocationRequest{}, fmt.Errorf("allocation expiration epoch %d is not after the current head %d", terms.Expiration, head.Height())
 	}
-
-	// Generate a diff to find new allocations
-	for i := range newallocations {
-		_, ok := oldallocations[i]
-		if ok {
-			delete(newallocations, i)
-		}
+	exp := terms.Expiration - head.Height()
+	if err := validateAllocationTerms(terms.TermMin, terms.TermMax, exp); err != nil {
+		return address.Undef, AllocationRequest{}, err
 	}
 
-	printAllocation(newallocations, false)
-
-	for aid, allocation := range newallocations {
-		if allocation.Data.String() == dealConfig.PieceCid {
-			logs.GetLogger().Infof("data cap allocate success for piece %s, allocation id: %d", dealConfig.PieceCid, aid)
-			return uint64(aid), nil
-		}
-	}
-	return 0, errors.New("not found allocation")
+	pieceSize, _ := utils.CalculatePieceSize(dealConfig.FileSize, true)
+	return walletAddr, AllocationRequest{
+		PieceCid:   dealConfig.PieceCid,
+		PieceSize:  pieceSize,
+		Miner:      dealConfig.MinerFid,
+		TermMin:    terms.TermMin,
+		TermMax:    terms.TermMax,
+		Expiration: exp,
+	}, nil
 }
 
 func printAllocation(allocations map[verifreg.AllocationId]verifreg.Allocation, json bool) error {
+	return printAllocationInfos(allocationInfos(allocations, 0), json)
+}
+
+func printAllocationInfos(infos []AllocationInfo, json bool) error {
 	// Map Keys. Corresponds to the standard tablewriter output
 	allocationID := "AllocationID"
 	client := "Client"
@@ -510,45 +479,8 @@ func printAllocation(allocations map[verifreg.AllocationId]verifreg.Allocation,
 	tMax := "TermMax"
 	expr := "Expiration"
 
-	var allocs []map[string]interface{}
-
-	for key, val := range allocations {
-		alloc := map[string]interface{}{
-			allocationID: key,
-			client:       val.Client,
-			provider:     val.Provider,
-			pieceCid:     val.Data,
-			pieceSize:    val.Size,
-			tMin:         val.TermMin,
-			tMax:         val.TermMax,
-			expr:         val.Expiration,
-		}
-		allocs = append(allocs, alloc)
-	}
-
 	if json {
-		type jalloc struct {
-			Client     abi.ActorID         `json:"client"`
-			Provider   abi.ActorID         `json:"provider"`
-			Data       cid.Cid             `json:"data"`
-			Size       abi.PaddedPieceSize `json:"size"`
-			TermMin    abi.ChainEpoch      `json:"term_min"`
-			TermMax    abi.ChainEpoch      `json:"term_max"`
-			Expiration abi.ChainEpoch      `json:"expiration"`
-		}
-		allocMap := make(map[verifreg13types.AllocationId]jalloc, len(allocations))
-		for id, allocation := range allocations {
-			allocMap[verifreg13types.AllocationId(id)] = jalloc{
-				Provider:   allocation.Provider,
-				Client:     allocation.Client,
-				Data:       allocation.Data,
-				Size:       allocation.Size,
-				TermMin:    allocation.TermMin,
-				TermMax:    allocation.TermMax,
-				Expiration: allocation.Expiration,
-			}
-		}
-		return cmd.PrintJson(map[string]any{"allocations": allocations})
+		return cmd.PrintJson(map[string]any{"allocations": infos})
 	} else {
 		// Init the tablewriter's columns
 		tw := tablewriter.New(
@@ -561,8 +493,17 @@ func printAllocation(allocations map[verifreg.AllocationId]verifreg.Allocation,
 			tablewriter.Col(tMax),
 			tablewriter.NewLineCol(expr))
 		// populate it with content
-		for _, alloc := range allocs {
-			tw.Write(alloc)
+		for _, info := range infos {
+			tw.Write(map[string]interface{}{
+				allocationID: info.ID,
+				client:       info.Client,
+				provider:     info.Provider,
+				pieceCid:     info.Data,
+				pieceSize:    info.Size,
+				tMin:         info.TermMin,
+				tMax:         info.TermMax,
+				expr:         info.Expiration,
+			})
 		}
 		// return the corresponding string
 		return tw.Flush(os.Stdout)
@@ -570,6 +511,9 @@ func printAllocation(allocations map[verifreg.AllocationId]verifreg.Allocation,
 }
 
 func (client *Client) StartDeal(dealConfig *model.DealConfig) (string, error) {
+	if err := normalizeDealAddresses(dealConfig); err != nil {
+		return "", err
+	}
 	minerPrice, _, err := ValidateDealConfig(client.lotus, dealConfig, true)
 	if err != nil {
 		return "", err
@@ -581,25 +525,10 @@ func (client *Client) StartDeal(dealConfig *model.DealConfig) (string, error) {
 }
 
 func (client *Client) StartDealDirect(pieceSize int64, epochPrice mbig.Int, dealConfig *model.DealConfig) (string, error) {
-	if !dealConfig.SkipConfirmation {
-		logs.GetLogger().Info("Do you confirm to submit the deal?")
-		logs.GetLogger().Info("Press Y/y to continue, other key to quit")
-		reader := bufio.NewReader(os.Stdin)
-		response, err := reader.ReadString('\n')
-		if err != nil {
-			logs.GetLogger().Error(err)
-			return "", err
-		}
-
-		response = strings.TrimRight(response, "\n")
-
-		if !strings.EqualFold(response, "Y") {
-			logs.GetLogger().Info("Your input is ", response, ". Now give up submit the deal.")
-			return "", err
-		}
-	}
-
 	dealConfig.PieceCid = strings.Trim(dealConfig.PieceCid, " ")
+	if err := normalizeDealAddresses(dealConfig); err != nil {
+		return "", err
+	}
 
 	dealParam := DealParam{
 		Provider:      dealConfig.MinerFid,
@@ -609,23 +538,34 @@ func (client *Client) StartDealDirect(pieceSize int64, epochPrice mbig.Int, deal
 		PayloadCid:    dealConfig.PayloadCid,
 		StartEpoch:    int(dealConfig.StartEpoch),
 		Duration:      dealConfig.Duration,
-		StoragePrice:  int(epochPrice.Int64()),
+		StoragePrice:  big.NewFromGo(&epochPrice),
 		Verified:      dealConfig.VerifiedDeal,
 		FastRetrieval: dealConfig.FastRetrieval,
 		Wallet:        dealConfig.SenderWallet,
 	}
 
-	dealUuid, err := client.sendDealToMiner(dealParam)
+	var confirmDeal ConfirmFunc
+	if !dealConfig.SkipConfirmation {
+		confirmDeal = client.dealConfirmFunc()
+	}
+
+	dealUuid, err := client.sendDealToMiner(dealParam, confirmDeal)
 	if err != nil {
-		logs.GetLogger().Error(err)
+		if errors.Is(err, ErrDealDeclined) {
+			logs.GetLogger().Info("Now give up submit the deal.")
+		} else {
+			logs.GetLogger().Error(err)
+		}
 		return "", err
 	}
 	return dealUuid, nil
 }
 
-func (client *Client) sendDealToMiner(dealP DealParam) (string, error) {
+// sendDealToMiner proposes the deal to the storage provider. If confirmDeal is
+// not nil, it has to confirm the deal proposal before it is sent.
+func (client *Client) sendDealToMiner(dealP DealParam, confirmDeal ConfirmFunc) (string, error) {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		return "", err
 	}
@@ -644,36 +584,47 @@ func (client *Client) sendDealToMiner(dealP DealParam) (string, error) {
 	}
 	defer closer()
 
-	walletAddr, err := n.GetProvidedOrDefaultWallet(ctx, dealP.Wallet)
+	walletAddr, err := client.dealWallet(ctx, n, dealP.Wallet)
 	if err != nil {
 		return "", err
 	}
 
 	logs.GetLogger().Warn("selected wallet: ", walletAddr)
 
-	maddr, err := address.NewFromString(dealP.Provider)
+	bundle, err := client.prepareDeal(ctx, fullNode, walletAddr, dealP)
 	if err != nil {
 		return "", err
 	}
+	dealUuid := bundle.Params.DealUUID
 
-	addrInfo, err := cmd.GetAddrInfo(ctx, fullNode, maddr)
-	if err != nil {
-		return "", err
+	// confirm on the unsigned proposal, so that declined deals never reach
+	// the signer
+	if confirmDeal != nil {
+		ok, err := confirmDeal(newDealSummary(dealP, bundle.Label, &bundle.Params.ClientDealProposal.Proposal))
+		if err != nil {
+			return "", fmt.Errorf("dealUuid: %s, confirming deal: %w", dealUuid.String(), err)
+		}
+		if !ok {
+			return "", fmt.Errorf("dealUuid: %s: %w", dealUuid.String(), ErrDealDeclined)
+		}
 	}
 
-	logs.GetLogger().Warn("found storage provider, ", "id: ", addrInfo.ID, ", multiaddrs: ", addrInfo.Addrs, ", minerID:", maddr)
-
-	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
-		return "", fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	if err := signDealProposal(ctx, client.nodeSigner(n), &bundle.Params.ClientDealProposal); err != nil {
+		return "", fmt.Errorf("dealUuid: %s, failed to create a deal proposal: %w", dealUuid.String(), err)
 	}
 
-	x, err := n.Host.Peerstore().FirstSupportedProtocol(addrInfo.ID, DealProtocolv120)
-	if err != nil {
-		return "", fmt.Errorf("getting protocols for peer %s: %w", addrInfo.ID, err)
+	return client.proposeDeal(ctx, n, fullNode, bundle)
+}
+
+// prepareDeal builds the unsigned deal proposal of the deal
+func (client *Client) prepareDeal(ctx context.Context, fullNode api.FullNode, walletAddr address.Address, dealP DealParam) (*DealBundle, error) {
+	if err := checkDealClient(ctx, fullNode, walletAddr); err != nil {
+		return nil, err
 	}
 
-	if len(x) == 0 {
-		return "", fmt.Errorf("boost client cannot make a deal with storage provider %s because it does not support protocol version 1.2.0", maddr)
+	maddr, err := ParseAddress(dealP.Provider)
+	if err != nil {
+		return nil, err
 	}
 
 	dealUuid := uuid.New()
@@ -681,95 +632,175 @@ func (client *Client) sendDealToMiner(dealP DealParam) (string, error) {
 	commp := dealP.Commp
 	pieceCid, err := cid.Parse(commp)
 	if err != nil {
-		return "", fmt.Errorf("parsing commp '%s': %w", commp, err)
+		return nil, fmt.Errorf("parsing commp '%s': %w", commp, err)
 	}
 
 	pieceSize := dealP.PieceSize
 	if pieceSize == 0 {
-		return "", fmt.Errorf("must provide piece-size parameter for CAR url")
+		return nil, fmt.Errorf("must provide piece-size parameter for CAR url")
 	}
 
 	payloadCidStr := dealP.PayloadCid
 	rootCid, err := cid.Parse(payloadCidStr)
 	if err != nil {
-		return "", fmt.Errorf("dealUuid: %s, parsing payload cid %s: %w", dealUuid.String(), payloadCidStr, err)
+		return nil, fmt.Errorf("dealUuid: %s, parsing payload cid %s: %w", dealUuid.String(), payloadCidStr, err)
+	}
+
+	label, labelText, err := dealLabel(dealP)
+	if err != nil {
+		return nil, fmt.Errorf("dealUuid: %s, %w", dealUuid.String(), err)
 	}
 
 	carFileSize := dealP.CarSize
 	if dealP.CarSize == 0 {
-		return "", fmt.Errorf("size of car file cannot be 0")
+		return nil, fmt.Errorf("size of car file cannot be 0")
 	}
 
 	transfer := types.Transfer{
 		Size: carFileSize,
 	}
 
-	var providerCollateral abi.TokenAmount
-	if dealP.ProviderCollateral != 0 {
-		providerCollateral = abi.NewTokenAmount(int64(dealP.ProviderCollateral))
-	} else {
-		bounds, err := fullNode.StateDealProviderCollateralBounds(ctx, abi.PaddedPieceSize(pieceSize), dealP.Verified, chaintypes.EmptyTSK)
-		if err != nil {
-			return "", fmt.Errorf("dealUuid: %s, node error getting collateral bounds: %w", dealUuid.String(), err)
-		}
-
-		providerCollateral = big.Div(big.Mul(bounds.Min, big.NewInt(6)), big.NewInt(5)) // add 20%
+	providerCollateral, err := client.dealProviderCollateral(ctx, fullNode, dealP)
+	if err != nil {
+		return nil, fmt.Errorf("dealUuid: %s, %w", dealUuid.String(), err)
 	}
 
 	tipset, err := fullNode.ChainHead(ctx)
 	if err != nil {
-		return "", fmt.Errorf("cannot get chain head: %w", err)
+		return nil, fmt.Errorf("cannot get chain head: %w", err)
 	}
 
 	head := tipset.Height()
 	logs.GetLogger().Debug("current block height", "number", head)
 
-	if dealP.StartEpoch != 0 && dealP.StartEpochHeadOffset != 0 {
-		return "", errors.New("only one flag from `start-epoch-head-offset' or `start-epoch` can be specified")
+	startEpoch, err := client.dealStartEpoch(ctx, tipset, dealP)
+	if err != nil {
+		return nil, err
 	}
 
-	var startEpoch abi.ChainEpoch
+	// Create a deal proposal to storage provider using deal protocol v1.2.0 format
+	proposal := dealProposal(walletAddr, label, abi.PaddedPieceSize(pieceSize), pieceCid, maddr, startEpoch, dealP.Duration, dealP.Verified, providerCollateral, tokenOrZero(dealP.StoragePrice))
 
-	if dealP.StartEpochHeadOffset != 0 {
-		startEpoch = head + abi.ChainEpoch(dealP.StartEpochHeadOffset)
-	} else if dealP.StartEpoch != 0 {
-		startEpoch = abi.ChainEpoch(dealP.StartEpoch)
-	} else {
-		// default
-		startEpoch = head + abi.ChainEpoch(5760) // head + 2 days
+	proposalCid, err := proposal.Cid()
+	if err != nil {
+		return nil, fmt.Errorf("dealUuid: %s, getting proposal cid: %w", dealUuid.String(), err)
+	}
+
+	deadline := startEpoch - DealSendBuffer
+	return &DealBundle{
+		Params: types.DealParams{
+			DealUUID:           dealUuid,
+			ClientDealProposal: market.ClientDealProposal{Proposal: proposal},
+			DealDataRoot:       rootCid,
+			IsOffline:          true,
+			Transfer:           transfer,
+			RemoveUnsealedCopy: false,
+			SkipIPNIAnnounce:   false,
+		},
+		ProposalCid:   proposalCid,
+		Label:         labelText,
+		ProposedEpoch: head,
+		Deadline:      deadline,
+		DeadlineTime:  TimeAtEpoch(tipset, deadline),
+	}, nil
+}
+
+// proposeDeal sends the signed deal proposal to the storage provider and
+// records it in the deal db
+func (client *Client) proposeDeal(ctx context.Context, n *clinode.Node, fullNode api.FullNode, bundle *DealBundle) (string, error) {
+	dealParams := bundle.Params
+	dealProposal := &dealParams.ClientDealProposal
+	dealUuid := dealParams.DealUUID
+	maddr := dealProposal.Proposal.Provider
+	walletAddr := dealProposal.Proposal.Client
+
+	dealDB, err := client.DealDB()
+	if err != nil {
+		return "", fmt.Errorf("opening deal db: %w", err)
 	}
+	defer dealDB.Close()
 
-	// Create a deal proposal to storage provider using deal protocol v1.2.0 format
-	dealProposal, err := dealProposal(ctx, n, walletAddr, rootCid, abi.PaddedPieceSize(pieceSize), pieceCid, maddr, startEpoch, dealP.Duration, dealP.Verified, providerCollateral, abi.NewTokenAmount(int64(dealP.StoragePrice)))
+	addrInfo, err := cmd.GetAddrInfo(ctx, fullNode, maddr)
 	if err != nil {
-		return "", fmt.Errorf("dealUuid: %s, failed to create a deal proposal: %w", dealUuid.String(), err)
+		return "", err
+	}
+
+	logs.GetLogger().Warn("found storage provider, ", "id: ", addrInfo.ID, ", multiaddrs: ", addrInfo.Addrs, ", minerID:", maddr)
+
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return "", fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
 	}
 
-	dealParams := types.DealParams{
-		DealUUID:           dealUuid,
-		ClientDealProposal: *dealProposal,
-		DealDataRoot:       rootCid,
-		IsOffline:          true,
-		Transfer:           transfer,
-		RemoveUnsealedCopy: false,
-		SkipIPNIAnnounce:   false,
+	x, err := n.Host.Peerstore().FirstSupportedProtocol(addrInfo.ID, DealProtocolv120)
+	if err != nil {
+		return "", fmt.Errorf("getting protocols for peer %s: %w", addrInfo.ID, err)
+	}
+
+	if len(x) == 0 {
+		return "", fmt.Errorf("boost client cannot make a deal with storage provider %s because it does not support protocol version 1.2.0", maddr)
+	}
+
+	// the escrow stays locked until the deal is recorded as proposed, from
+	// then on it is counted as pending escrow
+	unlock := lockEscrow(walletAddr)
+	if err := client.ensureEscrow(ctx, n, walletAddr, dealProposal.Proposal.ClientBalanceRequirement()); err != nil {
+		unlock()
+		return "", fmt.Errorf("dealUuid: %s, %w", dealUuid.String(), err)
+	}
+
+	proposalCid, err := dealProposal.Proposal.Cid()
+	if err != nil {
+		unlock()
+		return "", fmt.Errorf("dealUuid: %s, getting proposal cid: %w", dealUuid.String(), err)
+	}
+
+	dealRecord := &DealRecord{
+		ID:                 dealUuid,
+		Provider:           maddr.String(),
+		Wallet:             walletAddr.String(),
+		PieceCid:           dealProposal.Proposal.PieceCID.String(),
+		PieceSize:          uint64(dealProposal.Proposal.PieceSize),
+		PayloadCid:         dealParams.DealDataRoot.String(),
+		CarSize:            dealParams.Transfer.Size,
+		StartEpoch:         int64(dealProposal.Proposal.StartEpoch),
+		EndEpoch:           int64(dealProposal.Proposal.EndEpoch),
+		ProposedEpoch:      int64(bundle.ProposedEpoch),
+		StoragePrice:       dealProposal.Proposal.StoragePricePerEpoch.String(),
+		ProviderCollateral: dealProposal.Proposal.ProviderCollateral.String(),
+		ClientCollateral:   dealProposal.Proposal.ClientCollateral.String(),
+		Verified:           dealProposal.Proposal.VerifiedDeal,
+		ProposalCid:        proposalCid.String(),
+		Label:              bundle.Label,
+		State:              DealStateProposed,
+	}
+	err = dealDB.Insert(ctx, dealRecord)
+	unlock()
+	if err != nil {
+		return "", err
 	}
 
 	logs.GetLogger().Debug("about to submit deal proposal", "uuid", dealUuid.String())
 
 	s, err := n.Host.NewStream(ctx, addrInfo.ID, DealProtocolv120)
 	if err != nil {
+		dealDB.UpdateState(ctx, dealUuid, DealStateError, err.Error()) //nolint:errcheck
 		return "", fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
 	}
 	defer s.Close()
 
 	var resp types.DealResponse
 	if err := doRpc(ctx, s, &dealParams, &resp); err != nil {
+		dealDB.UpdateState(ctx, dealUuid, DealStateError, err.Error()) //nolint:errcheck
 		return "", fmt.Errorf("send proposal rpc: %w", err)
 	}
 
 	if !resp.Accepted {
-		return "", fmt.Errorf("deal proposal rejected: %s", resp.Message)
+		dealDB.UpdateState(ctx, dealUuid, DealStateRejected, resp.Message) //nolint:errcheck
+		return "", fmt.Errorf("%w: %s", ErrDealRejected, resp.Message)
+	}
+
+	if err := dealDB.UpdateState(ctx, dealUuid, DealStateAccepted, resp.Message); err != nil {
+		logs.GetLogger().Error("dealUuid: ", dealUuid.String(), ", failed to record accepted deal: ", err)
 	}
 
 	fmt.Println("dealUuid: ", dealUuid.String(), ", the deal proposal has been sent to the storage provider, the deal info is as follows: ")
@@ -777,8 +808,10 @@ func (client *Client) sendDealToMiner(dealP DealParam) (string, error) {
 		"dealUuid":           dealUuid.String(),
 		"provider":           maddr.String(),
 		"clientWallet":       walletAddr.String(),
-		"payloadCid":         rootCid.String(),
+		"payloadCid":         dealParams.DealDataRoot.String(),
+		"label":              bundle.Label,
 		"commp":              dealProposal.Proposal.PieceCID.String(),
+		"proposalCid":        proposalCid.String(),
 		"startEpoch":         dealProposal.Proposal.StartEpoch.String(),
 		"endEpoch":           dealProposal.Proposal.EndEpoch.String(),
 		"providerCollateral": dealProposal.Proposal.ProviderCollateral.String(),
@@ -786,42 +819,142 @@ func (client *Client) sendDealToMiner(dealP DealParam) (string, error) {
 	return dealUuid.String(), cmd.PrintJson(out)
 }
 
-func dealProposal(ctx context.Context, n *clinode.Node, clientAddr address.Address, rootCid cid.Cid, pieceSize abi.PaddedPieceSize, pieceCid cid.Cid, minerAddr address.Address, startEpoch abi.ChainEpoch, duration int, verified bool, providerCollateral abi.TokenAmount, storagePrice abi.TokenAmount) (*market.ClientDealProposal, error) {
-	endEpoch := startEpoch + abi.ChainEpoch(duration)
-	// deal proposal expects total storage price for deal per epoch, therefore we
-	// multiply pieceSize * storagePrice (which is set per epoch per GiB) and divide by 2^30
-	storagePricePerEpochForDeal := big.Div(big.Mul(big.NewInt(int64(pieceSize)), storagePrice), big.NewInt(int64(1<<30)))
-	l, err := market.NewLabelFromString(rootCid.String())
+// DealStatus queries the storage provider for the status of a deal sent by this
+// client and records the reported status in the deal db
+func (client *Client) DealStatus(dealUuid string) (*types.DealStatusResponse, error) {
+	ctx := context.Background()
+	dealUid, err := uuid.Parse(dealUuid)
+	if err != nil {
+		return nil, fmt.Errorf("dealUuid=[%s] parse failed: %w", dealUuid, err)
+	}
+
+	dealDB, err := client.DealDB()
+	if err != nil {
+		return nil, fmt.Errorf("opening deal db: %w", err)
+	}
+	defer dealDB.Close()
+
+	deal, err := dealDB.ByID(ctx, dealUid)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return nil, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	resp, err := queryDealStatus(ctx, n, client.nodeSigner(n), fullNode, deal)
+	if err != nil {
+		return nil, err
+	}
+
+	state, message := resp.DealStatus.Status, resp.DealStatus.Error
+	if message == "" {
+		message = resp.DealStatus.SealingStatus
+	}
+	if err := dealDB.UpdateState(ctx, dealUid, state, message); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func queryDealStatus(ctx context.Context, n *clinode.Node, signer Signer, fullNode api.FullNode, deal *DealRecord) (*types.DealStatusResponse, error) {
+	maddr, err := ParseAddress(deal.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	walletAddr, err := ParseAddress(deal.Wallet)
 	if err != nil {
 		return nil, err
 	}
-	proposal := market.DealProposal{
+
+	addrInfo, err := cmd.GetAddrInfo(ctx, fullNode, maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	uuidBytes, err := deal.ID.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("getting uuid bytes: %w", err)
+	}
+
+	sig, err := signer.WalletSign(ctx, walletAddr, uuidBytes, api.MsgMeta{Type: api.MTUnknown})
+	if err != nil {
+		return nil, fmt.Errorf("signing uuid bytes: %w", err)
+	}
+
+	s, err := n.Host.NewStream(ctx, addrInfo.ID, DealStatusProtocolv120)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
+	}
+	defer s.Close()
+
+	req := types.DealStatusRequest{DealUUID: deal.ID, Signature: *sig}
+	var resp types.DealStatusResponse
+	if err := doRpc(ctx, s, &req, &resp); err != nil {
+		return nil, fmt.Errorf("send deal status rpc: %w", err)
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("dealUuid: %s, deal status error: %s", deal.ID, resp.Error)
+	}
+	if resp.DealStatus == nil {
+		return nil, fmt.Errorf("dealUuid: %s, provider returned no deal status", deal.ID)
+	}
+	return &resp, nil
+}
+
+func dealProposal(clientAddr address.Address, label market.DealLabel, pieceSize abi.PaddedPieceSize, pieceCid cid.Cid, minerAddr address.Address, startEpoch abi.ChainEpoch, duration int, verified bool, providerCollateral abi.TokenAmount, storagePrice abi.TokenAmount) market.DealProposal {
+	endEpoch := startEpoch + abi.ChainEpoch(duration)
+	storagePricePerEpochForDeal := dealStoragePricePerEpoch(pieceSize, storagePrice)
+	return market.DealProposal{
 		PieceCID:             pieceCid,
 		PieceSize:            pieceSize,
 		VerifiedDeal:         verified,
 		Client:               clientAddr,
 		Provider:             minerAddr,
-		Label:                l,
+		Label:                label,
 		StartEpoch:           startEpoch,
 		EndEpoch:             endEpoch,
 		StoragePricePerEpoch: storagePricePerEpochForDeal,
 		ProviderCollateral:   providerCollateral,
+		ClientCollateral:     big.Zero(),
 	}
+}
 
-	buf, err := cborutil.Dump(&proposal)
+// signDealProposal signs the deal proposal with the client wallet
+func signDealProposal(ctx context.Context, signer Signer, proposal *market.ClientDealProposal) error {
+	buf, err := cborutil.Dump(&proposal.Proposal)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	sig, err := n.Wallet.WalletSign(ctx, clientAddr, buf, api.MsgMeta{Type: api.MTDealProposal})
+	sig, err := signer.WalletSign(ctx, proposal.Proposal.Client, buf, api.MsgMeta{Type: api.MTDealProposal})
 	if err != nil {
-		return nil, fmt.Errorf("wallet sign failed: %w", err)
+		return fmt.Errorf("wallet sign failed: %w", err)
 	}
+	proposal.ClientSignature = *sig
+	return nil
+}
 
-	return &market.ClientDealProposal{
-		Proposal:        proposal,
-		ClientSignature: *sig,
-	}, nil
+// dealStoragePricePerEpoch returns the total storage price of a deal per epoch.
+// Deal proposal expects total storage price for deal per epoch, therefore we
+// multiply pieceSize * storagePrice (which is set per epoch per GiB) and divide by 2^30
+func dealStoragePricePerEpoch(pieceSize abi.PaddedPieceSize, storagePrice abi.TokenAmount) abi.TokenAmount {
+	return big.Div(big.Mul(big.NewInt(int64(pieceSize)), storagePrice), big.NewInt(int64(1<<30)))
 }
 
 func doRpc(ctx context.Context, s inet.Stream, req interface{}, resp interface{}) error {
@@ -849,24 +982,66 @@ func doRpc(ctx context.Context, s inet.Stream, req interface{}, resp interface{}
 }
 
 type DealParam struct {
-	Provider             string `json:"provider"`                // storage provider on-chain address. Required
-	Commp                string `json:"commp"`                   // commp of the CAR file. Required
-	PieceSize            uint64 `json:"piece_size"`              // size of the CAR file as a padded piece. Required
-	CarSize              uint64 `json:"car_size"`                // size of the CAR file. Required
-	PayloadCid           string `json:"payload_cid"`             // root CID of the CAR file. Required
-	StartEpoch           int    `json:"start_epoch"`             // start epoch by when the deal should be proved by provider on-chain. default: current chain head + 2 days
-	StartEpochHeadOffset int    `json:"start_epoch_head_offset"` // start epoch head offset
-	Duration             int    `json:"duration"`                // duration of the deal in epochs. default is 2880 * 180 == 180 days  518400
-	ProviderCollateral   int    `json:"provider_collateral"`     // deal collateral that storage miner must put in escrow; if empty, the min collateral for the given piece size will be used
-	StoragePrice         int    `json:"storage_price"`           // storage price in attoFIL per epoch per GiB. default 1
-	Verified             bool   `json:"verified"`                // whether the deal funds should come from verified client data-cap. default true
-	FastRetrieval        bool   `json:"fast_retrieval"`          // indicates that data should be available for fast retrieval. default true
-	Wallet               string `json:"wallet"`                  // wallet address to be used to initiate the deal
+	Provider             string          `json:"provider"`                // storage provider on-chain address. Required
+	Commp                string          `json:"commp"`                   // commp of the CAR file. Required
+	PieceSize            uint64          `json:"piece_size"`              // size of the CAR file as a padded piece. Required
+	CarSize              uint64          `json:"car_size"`                // size of the CAR file. Required
+	PayloadCid           string          `json:"payload_cid"`             // root CID of the CAR file. Required
+	StartEpoch           int             `json:"start_epoch"`             // start epoch by when the deal should be proved by provider on-chain. default: planned by the start epoch planner of the client
+	StartEpochHeadOffset int             `json:"start_epoch_head_offset"` // start epoch head offset
+	StartTime            *time.Time      `json:"start_time,omitempty"`    // start of the deal as a date, converted to an epoch with the network block time
+	Duration             int             `json:"duration"`                // duration of the deal in epochs. default is 2880 * 180 == 180 days  518400
+	ProviderCollateral   abi.TokenAmount `json:"provider_collateral"`     // deal collateral in attoFIL that storage miner must put in escrow; if empty, it is chosen by the collateral policy of the client
+	StoragePrice         abi.TokenAmount `json:"storage_price"`           // storage price in attoFIL per epoch per GiB. default 0
+	Verified             bool            `json:"verified"`                // whether the deal funds should come from verified client data-cap. default true
+	FastRetrieval        bool            `json:"fast_retrieval"`          // indicates that data should be available for fast retrieval. default true
+	Wallet               string          `json:"wallet"`                  // wallet address to be used to initiate the deal
+	LabelMode            string          `json:"label_mode,omitempty"`    // how the label of the deal proposal is built, LabelModePayloadCid by default
+	Label                string          `json:"label,omitempty"`         // label for LabelModeString, or hex encoded bytes for LabelModeBytes; at most market.DealMaxLabelSize bytes
+}
+
+// UnmarshalJSON reads the provider collateral and storage price either as
+// strings, which hold any amount, or as JSON numbers as in older configs
+func (dealP *DealParam) UnmarshalJSON(data []byte) error {
+	type dealParam DealParam
+	aux := struct {
+		*dealParam
+		ProviderCollateral json.RawMessage `json:"provider_collateral"`
+		StoragePrice       json.RawMessage `json:"storage_price"`
+	}{dealParam: (*dealParam)(dealP)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if dealP.ProviderCollateral, err = parseTokenJSON(aux.ProviderCollateral); err != nil {
+		return fmt.Errorf("parsing provider_collateral: %w", err)
+	}
+	if dealP.StoragePrice, err = parseTokenJSON(aux.StoragePrice); err != nil {
+		return fmt.Errorf("parsing storage_price: %w", err)
+	}
+	return nil
+}
+
+func parseTokenJSON(raw json.RawMessage) (abi.TokenAmount, error) {
+	s := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if s == "null" {
+		s = ""
+	}
+	return parseToken(s)
+}
+
+// tokenOrZero returns zero for an unset token amount
+func tokenOrZero(t abi.TokenAmount) abi.TokenAmount {
+	if t.Nil() {
+		return big.Zero()
+	}
+	return t
 }
 
 func (client *Client) StorageAsk(provider string, size int64, duration int64) (*AskInfo, error) {
 	ctx := context.Background()
-	n, err := clinode.Setup(client.ClientRepo)
+	n, err := client.setupNode()
 	if err != nil {
 		return nil, err
 	}
@@ -884,38 +1059,15 @@ func (client *Client) StorageAsk(provider string, size int64, duration int64) (*
 		return nil, fmt.Errorf("cant setup fullnode connection: %w", err)
 	}
 	defer closer()
-	maddr, err := address.NewFromString(provider)
+	maddr, err := ParseAddress(provider)
 	if err != nil {
 		return nil, err
 	}
 
-	addrInfo, err := cmd.GetAddrInfo(ctx, fullNode, maddr)
+	ask, err := queryAsk(ctx, n.Host, fullNode, maddr)
 	if err != nil {
 		return nil, err
 	}
-	logs.GetLogger().Debug("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
-
-	if err := n.Host.Connect(ctx, *addrInfo); err != nil {
-		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
-	}
-
-	s, err := n.Host.NewStream(ctx, addrInfo.ID, AskProtocolID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
-	}
-	defer s.Close()
-
-	var resp network.AskResponse
-
-	askRequest := network.AskRequest{
-		Miner: maddr,
-	}
-
-	if err := doRpc(ctx, s, &askRequest, &resp); err != nil {
-		return nil, fmt.Errorf("send ask request rpc: %w", err)
-	}
-
-	ask := resp.Ask.Ask
 
 	logs.GetLogger().Infof("Ask: %s\n", maddr)
 	logs.GetLogger().Infof("Price per GiB: %s\n", chaintypes.FIL(ask.Price))
@@ -941,6 +1093,39 @@ func (client *Client) StorageAsk(provider string, size int64, duration int64) (*
 	return info, nil
 }
 
+func queryAsk(ctx context.Context, h host.Host, fullNode api.FullNode, maddr address.Address) (*legacytypes.StorageAsk, error) {
+	addrInfo, err := cmd.GetAddrInfo(ctx, fullNode, maddr)
+	if err != nil {
+		return nil, err
+	}
+	logs.GetLogger().Debug("found storage provider", "id", addrInfo.ID, "multiaddrs", addrInfo.Addrs, "addr", maddr)
+
+	if err := h.Connect(ctx, *addrInfo); err != nil {
+		return nil, fmt.Errorf("failed to connect to peer %s: %w", addrInfo.ID, err)
+	}
+
+	s, err := h.NewStream(ctx, addrInfo.ID, AskProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream to peer %s: %w", addrInfo.ID, err)
+	}
+	defer s.Close()
+
+	var resp network.AskResponse
+
+	askRequest := network.AskRequest{
+		Miner: maddr,
+	}
+
+	if err := doRpc(ctx, s, &askRequest, &resp); err != nil {
+		return nil, fmt.Errorf("send ask request rpc: %w", err)
+	}
+
+	if resp.Ask == nil || resp.Ask.Ask == nil {
+		return nil, fmt.Errorf("storage provider %s returned an empty ask", maddr)
+	}
+	return resp.Ask.Ask, nil
+}
+
 type AskInfo struct {
 	legacytypes.StorageAsk
 	EpochPrice big.Int
@@ -1036,7 +1221,8 @@ func (client *Client) QueryAsk(miner string) (*lotus.MinerConfig, error) {
 
 func CheckDealWithMinerConfig(lotusClient *lotus.LotusClient, dealConfig *model.DealConfig, minerConfig *lotus.MinerConfig) (*decimal.Decimal, error) {
 	if dealConfig.FileSize < minerConfig.MinPieceSize || dealConfig.FileSize > minerConfig.MaxPieceSize {
-		err := fmt.Errorf("payload cid:%s, file size:%d is outside of miner:%s's range:[%d,%d]", dealConfig.PayloadCid, dealConfig.FileSize, dealConfig.MinerFid, minerConfig.MinPieceSize, minerConfig.MaxPieceSize)
+		err := fmt.Errorf("payload cid:%s, file size:%d is outside of miner:%s's range:[%d,%d], split the data with dataprep.PlanChunks to fit the range",
+			dealConfig.PayloadCid, dealConfig.FileSize, dealConfig.MinerFid, minerConfig.MinPieceSize, minerConfig.MaxPieceSize)
 		logs.GetLogger().Error(err)
 		return nil, err
 	}
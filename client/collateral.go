@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+)
+
+// CollateralPolicy chooses the provider collateral of deals that do not set
+// DealParam.ProviderCollateral. Amounts above the bounds maximum are capped at
+// the maximum.
+type CollateralPolicy interface {
+	ProviderCollateral(provider string, bounds api.DealCollateralBounds) (abi.TokenAmount, error)
+}
+
+// DefaultCollateralPolicy is 20% above the minimum provider collateral
+var DefaultCollateralPolicy CollateralPolicy = MinCollateralMultiplier{Percent: 120}
+
+// MinCollateralMultiplier is the minimum provider collateral times Percent/100
+type MinCollateralMultiplier struct {
+	Percent int64
+}
+
+func (m MinCollateralMultiplier) ProviderCollateral(_ string, bounds api.DealCollateralBounds) (abi.TokenAmount, error) {
+	if m.Percent < 100 {
+		return abi.TokenAmount{}, fmt.Errorf("collateral multiplier %d%% is below the minimum collateral", m.Percent)
+	}
+	return big.Div(big.Mul(bounds.Min, big.NewInt(m.Percent)), big.NewInt(100)), nil
+}
+
+// FixedCollateral is the same provider collateral for every deal
+type FixedCollateral struct {
+	Amount abi.TokenAmount
+}
+
+func (f FixedCollateral) ProviderCollateral(_ string, _ api.DealCollateralBounds) (abi.TokenAmount, error) {
+	if f.Amount.Nil() {
+		return abi.TokenAmount{}, fmt.Errorf("fixed collateral is not set")
+	}
+	return f.Amount, nil
+}
+
+// ProviderCollateralOverrides uses the policy of the provider if there is one,
+// and the default policy otherwise
+type ProviderCollateralOverrides struct {
+	Default   CollateralPolicy            // DefaultCollateralPolicy if nil
+	Providers map[string]CollateralPolicy // policy by provider address, in any form ParseAddress accepts
+}
+
+func (o ProviderCollateralOverrides) ProviderCollateral(provider string, bounds api.DealCollateralBounds) (abi.TokenAmount, error) {
+	// the same provider can be given as f0, t0 or 0x address
+	maddr, err := ParseAddress(provider)
+	if err != nil {
+		return abi.TokenAmount{}, fmt.Errorf("invalid provider: %w", err)
+	}
+	for p, policy := range o.Providers {
+		paddr, err := ParseAddress(p)
+		if err != nil {
+			return abi.TokenAmount{}, fmt.Errorf("invalid provider of a collateral override: %w", err)
+		}
+		if paddr == maddr {
+			return policy.ProviderCollateral(provider, bounds)
+		}
+	}
+	if o.Default != nil {
+		return o.Default.ProviderCollateral(provider, bounds)
+	}
+	return DefaultCollateralPolicy.ProviderCollateral(provider, bounds)
+}
+
+// WithCollateralPolicy sets the policy choosing the provider collateral of
+// deals that do not set it
+func (client *Client) WithCollateralPolicy(policy CollateralPolicy) *Client {
+	client.collateralPolicy = policy
+	return client
+}
+
+type collateralBoundsAPI interface {
+	StateDealProviderCollateralBounds(context.Context, abi.PaddedPieceSize, bool, chaintypes.TipSetKey) (api.DealCollateralBounds, error)
+}
+
+// dealProviderCollateral returns the provider collateral of the deal: the
+// given collateral, or the one chosen by the collateral policy. It is checked
+// against the collateral bounds, so that the provider does not reject the
+// proposal for it.
+func (client *Client) dealProviderCollateral(ctx context.Context, node collateralBoundsAPI, dealP DealParam) (abi.TokenAmount, error) {
+	bounds, err := node.StateDealProviderCollateralBounds(ctx, abi.PaddedPieceSize(dealP.PieceSize), dealP.Verified, chaintypes.EmptyTSK)
+	if err != nil {
+		return abi.TokenAmount{}, fmt.Errorf("node error getting collateral bounds: %w", err)
+	}
+
+	collateral := dealP.ProviderCollateral
+	if collateral.Nil() || collateral.IsZero() {
+		policy := client.collateralPolicy
+		if policy == nil {
+			policy = DefaultCollateralPolicy
+		}
+		collateral, err = policy.ProviderCollateral(dealP.Provider, bounds)
+		if err != nil {
+			return abi.TokenAmount{}, fmt.Errorf("choosing provider collateral: %w", err)
+		}
+		if big.Cmp(collateral, bounds.Max) > 0 {
+			collateral = bounds.Max
+		}
+	}
+
+	if big.Cmp(collateral, bounds.Min) < 0 || big.Cmp(collateral, bounds.Max) > 0 {
+		return abi.TokenAmount{}, fmt.Errorf("provider collateral %s is outside of the bounds [%s,%s]", collateral, bounds.Min, bounds.Max)
+	}
+	return collateral, nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrDealDeclined is returned when the deal was not confirmed before sending it
+// to the storage provider
+var ErrDealDeclined = errors.New("deal declined")
+
+// DealSummary describes a deal proposal before it is sent to the storage provider
+type DealSummary struct {
+	Provider           string
+	Wallet             string
+	PayloadCid         string
+	Label              string // string label, or hex encoded bytes label
+	PieceCid           string
+	PieceSize          abi.PaddedPieceSize
+	CarSize            uint64
+	Verified           bool
+	StartEpoch         abi.ChainEpoch
+	EndEpoch           abi.ChainEpoch
+	Duration           abi.ChainEpoch
+	PricePerEpoch      abi.TokenAmount // total storage price of the deal per epoch
+	TotalPrice         abi.TokenAmount // storage price over the deal duration
+	ClientCollateral   abi.TokenAmount
+	ProviderCollateral abi.TokenAmount
+	Escrow             abi.TokenAmount // escrow the deal locks in the market actor
+}
+
+// ConfirmFunc decides whether a deal is sent to the storage provider. The deal
+// is declined with ErrDealDeclined if it returns false.
+type ConfirmFunc func(DealSummary) (bool, error)
+
+// WithConfirmFunc sets the function confirming deals started without
+// SkipConfirmation. By default, the deal summary is printed and confirmed on
+// the terminal.
+func (client *Client) WithConfirmFunc(fn ConfirmFunc) *Client {
+	client.confirmFunc = fn
+	return client
+}
+
+func (client *Client) dealConfirmFunc() ConfirmFunc {
+	if client.confirmFunc != nil {
+		return client.confirmFunc
+	}
+	return confirmDealOnTerminal
+}
+
+func newDealSummary(dealP DealParam, label string, proposal *market.DealProposal) DealSummary {
+	duration := proposal.EndEpoch - proposal.StartEpoch
+	return DealSummary{
+		Provider:           proposal.Provider.String(),
+		Wallet:             proposal.Client.String(),
+		PayloadCid:         dealP.PayloadCid,
+		Label:              label,
+		PieceCid:           proposal.PieceCID.String(),
+		PieceSize:          proposal.PieceSize,
+		CarSize:            dealP.CarSize,
+		Verified:           proposal.VerifiedDeal,
+		StartEpoch:         proposal.StartEpoch,
+		EndEpoch:           proposal.EndEpoch,
+		Duration:           duration,
+		PricePerEpoch:      proposal.StoragePricePerEpoch,
+		TotalPrice:         big.Mul(proposal.StoragePricePerEpoch, big.NewInt(int64(duration))),
+		ClientCollateral:   proposal.ClientCollateral,
+		ProviderCollateral: proposal.ProviderCollateral,
+		Escrow:             proposal.ClientBalanceRequirement(),
+	}
+}
+
+func confirmDealOnTerminal(s DealSummary) (bool, error) {
+	fmt.Println("provider:            ", s.Provider)
+	fmt.Println("wallet:              ", s.Wallet)
+	fmt.Println("payload cid:         ", s.PayloadCid)
+	fmt.Println("label:               ", s.Label)
+	fmt.Println("piece cid:           ", s.PieceCid)
+	fmt.Println("piece size:          ", s.PieceSize)
+	fmt.Println("verified:            ", s.Verified)
+	fmt.Println("start epoch:         ", s.StartEpoch)
+	fmt.Println("end epoch:           ", s.EndEpoch)
+	fmt.Println("price per epoch:     ", chaintypes.FIL(s.PricePerEpoch))
+	fmt.Println("total price:         ", chaintypes.FIL(s.TotalPrice))
+	fmt.Println("client collateral:   ", chaintypes.FIL(s.ClientCollateral))
+	fmt.Println("provider collateral: ", chaintypes.FIL(s.ProviderCollateral))
+	fmt.Println()
+	return confirm("Do you confirm to submit the deal? Yes [Y/y] / No [N/n], Ctrl+C (^C) to exit")
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	verifreg13types "github.com/filecoin-project/go-state-types/builtin/v13/verifreg"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+)
+
+// DealCost is the estimated cost of a deal
+type DealCost struct {
+	StartEpoch         abi.ChainEpoch
+	EndEpoch           abi.ChainEpoch
+	PricePerEpoch      abi.TokenAmount // total storage price of the deal per epoch
+	TotalPrice         abi.TokenAmount // storage price over the deal duration
+	ClientCollateral   abi.TokenAmount
+	ProviderCollateral abi.TokenAmount
+	Escrow             abi.TokenAmount // escrow the deal locks in the market actor: the total price and the client collateral
+
+	// Allocation messages of a verified deal made with AllocateDeal; not
+	// estimated for unverified deals
+	AllocationMessages int
+	AllocationGas      int64           // gas limit of the allocation messages
+	AllocationFee      abi.TokenAmount // max fee of the allocation messages
+	AllocationError    string          // why the allocation messages could not be estimated
+}
+
+// EstimateDealCost estimates what the deal costs the client, with the same
+// price, collateral and start epoch the deal proposal would use. For verified
+// deals, it also estimates the gas of the messages allocating DataCap to the
+// piece, with the allocation terms AllocateDeal uses by default.
+func (client *Client) EstimateDealCost(ctx context.Context, dealP DealParam) (*DealCost, error) {
+	if dealP.PieceSize == 0 {
+		return nil, fmt.Errorf("must provide piece-size parameter")
+	}
+	pieceSize := abi.PaddedPieceSize(dealP.PieceSize)
+	if err := pieceSize.Validate(); err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get chain head: %w", err)
+	}
+	startEpoch, err := client.dealStartEpoch(ctx, head, dealP)
+	if err != nil {
+		return nil, err
+	}
+	duration := abi.ChainEpoch(dealP.Duration)
+
+	providerCollateral, err := client.dealProviderCollateral(ctx, gapi, dealP)
+	if err != nil {
+		return nil, err
+	}
+	clientCollateral, _ := market.DealClientCollateralBounds(pieceSize, duration)
+
+	pricePerEpoch := dealStoragePricePerEpoch(pieceSize, tokenOrZero(dealP.StoragePrice))
+	totalPrice := big.Mul(pricePerEpoch, big.NewInt(int64(duration)))
+	cost := &DealCost{
+		StartEpoch:         startEpoch,
+		EndEpoch:           startEpoch + duration,
+		PricePerEpoch:      pricePerEpoch,
+		TotalPrice:         totalPrice,
+		ClientCollateral:   clientCollateral,
+		ProviderCollateral: providerCollateral,
+		Escrow:             big.Add(totalPrice, clientCollateral),
+		AllocationFee:      big.Zero(),
+	}
+	if !dealP.Verified {
+		return cost, nil
+	}
+
+	// a failed estimate of the allocation does not fail the estimate of the deal
+	walletAddr, err := ParseAddress(dealP.Wallet)
+	if err != nil {
+		cost.AllocationError = fmt.Sprintf("parsing wallet %q: %s", dealP.Wallet, err)
+		return cost, nil
+	}
+	req := AllocationRequest{
+		PieceCid:   dealP.Commp,
+		PieceSize:  int64(pieceSize),
+		Miner:      dealP.Provider,
+		TermMin:    duration,
+		TermMax:    verifreg13types.MaximumVerifiedAllocationTerm,
+		Expiration: startEpoch - head.Height(),
+	}
+	plan, err := client.dryRunAllocateDeals(ctx, gapi, walletAddr, []AllocationRequest{req}, 1)
+	if err != nil {
+		cost.AllocationError = err.Error()
+		return cost, nil
+	}
+	cost.AllocationMessages = len(plan.Messages)
+	for _, msg := range plan.Messages {
+		cost.AllocationGas += msg.GasLimit
+	}
+	cost.AllocationFee = plan.MaxFee
+	return cost, nil
+}
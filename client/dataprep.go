@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/filswan/swan-boost-lib/dataprep"
+)
+
+// WithCar returns a copy of the deal with the payload cid, commp and sizes of
+// a CAR prepared by the dataprep package
+func (dealP DealParam) WithCar(car *dataprep.CarInfo) DealParam {
+	dealP.PayloadCid = car.PayloadCid.String()
+	dealP.Commp = car.PieceCid.String()
+	dealP.PieceSize = uint64(car.PieceSize)
+	dealP.CarSize = car.CarSize
+	return dealP
+}
+
+// ChunkDeal is the deal made for a chunk of a dataset
+type ChunkDeal struct {
+	Chunk    int
+	DealUuid string
+	Error    string
+}
+
+// ProviderPieceLimits returns the range of piece sizes the provider accepts:
+// the piece size range of its ask, with the maximum capped at its sector size.
+// It is meant as the input of dataprep.PlanChunks.
+func (client *Client) ProviderPieceLimits(provider string) (abi.PaddedPieceSize, abi.PaddedPieceSize, error) {
+	ctx := context.Background()
+	maddr, err := ParseAddress(provider)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	n, err := client.setupNode()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer n.Host.Close()
+
+	ask, err := queryAsk(ctx, n.Host, fullNode, maddr)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	minfo, err := fullNode.StateMinerInfo(ctx, maddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting miner info of %s: %w", maddr, err)
+	}
+
+	maxPieceSize := ask.MaxPieceSize
+	if sectorSize := abi.PaddedPieceSize(minfo.SectorSize); maxPieceSize == 0 || maxPieceSize > sectorSize {
+		maxPieceSize = sectorSize
+	}
+	return ask.MinPieceSize, maxPieceSize, nil
+}
+
+// StartChunkDeals sends a deal for each built chunk of the manifest to the
+// provider of dealP. A failed chunk does not stop the others; its error is
+// reported in the result.
+func (client *Client) StartChunkDeals(m *dataprep.Manifest, dealP DealParam) []ChunkDeal {
+	var deals []ChunkDeal
+	for _, chunk := range m.Chunks {
+		deal := ChunkDeal{Chunk: chunk.Index}
+		if chunk.Car == nil {
+			deal.Error = "chunk car is not built"
+			deals = append(deals, deal)
+			continue
+		}
+
+		dealUuid, err := client.sendDealToMiner(dealP.WithCar(chunk.Car), nil)
+		if err != nil {
+			logs.GetLogger().Error("chunk ", chunk.Index, ": ", err)
+			deal.Error = err.Error()
+		} else {
+			logs.GetLogger().Info("chunk ", chunk.Index, ": deal ", dealUuid, " sent to ", dealP.Provider)
+			deal.DealUuid = dealUuid
+		}
+		deals = append(deals, deal)
+	}
+	return deals
+}
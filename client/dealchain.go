@@ -0,0 +1,315 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/lotus/api"
+	lmarket "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+)
+
+// dealTracker resolves the on-chain state of deals sent by the client
+type dealTracker struct {
+	client   *Client
+	fullNode api.FullNode
+	db       *DealDB
+	node     *clinode.Node
+}
+
+// TrackDeal resolves the on-chain state of a deal: it finds the provider's
+// PublishStorageDeals message, matches the proposal to its chain deal ID and
+// follows the market deal until the sector start epoch is set. If publishCid is
+// empty, the publish message cid is requested from the provider.
+func (client *Client) TrackDeal(dealUuid string, publishCid string) (*DealChainInfo, error) {
+	ctx := context.Background()
+	dealUid, err := uuid.Parse(dealUuid)
+	if err != nil {
+		return nil, fmt.Errorf("dealUuid=[%s] parse failed: %w", dealUuid, err)
+	}
+
+	t, closer, err := client.newDealTracker()
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	deal, err := t.db.ByID(ctx, dealUid)
+	if err != nil {
+		return nil, err
+	}
+	return t.track(ctx, deal, publishCid)
+}
+
+// TrackDeals resolves the on-chain state of every accepted deal that has not
+// been slashed or expired yet
+func (client *Client) TrackDeals(ctx context.Context) ([]*DealChainInfo, error) {
+	t, closer, err := client.newDealTracker()
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	deals, err := t.db.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*DealChainInfo
+	for _, deal := range deals {
+		if !isLiveDealState(deal.State) {
+			continue
+		}
+		info, err := t.track(ctx, deal, "")
+		if err != nil {
+			logs.GetLogger().Error("dealUuid: ", deal.ID.String(), ", failed to track deal: ", err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// WatchDeals calls TrackDeals every interval until the context is cancelled
+func (client *Client) WatchDeals(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := client.TrackDeals(ctx); err != nil {
+			logs.GetLogger().Error("tracking deals failed: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isLiveDealState is true for deals that were accepted by the provider and
+// have not been slashed or expired yet
+func isLiveDealState(state string) bool {
+	switch state {
+	case DealStateProposed, DealStateRejected, DealStateError, DealStateSlashed, DealStateExpired:
+		return false
+	}
+	return true
+}
+
+func (client *Client) newDealTracker() (*dealTracker, func(), error) {
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+
+	dealDB, err := client.DealDB()
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("opening deal db: %w", err)
+	}
+
+	t := &dealTracker{
+		client:   client,
+		fullNode: fullNode,
+		db:       dealDB,
+	}
+	return t, func() {
+		if t.node != nil {
+			t.node.Host.Close() //nolint:errcheck
+		}
+		dealDB.Close() //nolint:errcheck
+		closer()
+	}, nil
+}
+
+func (t *dealTracker) track(ctx context.Context, deal *DealRecord, publishCid string) (*DealChainInfo, error) {
+	info, err := t.db.ChainInfo(ctx, deal.ID)
+	if err != nil {
+		return nil, err
+	}
+	if publishCid != "" {
+		info.PublishCid = publishCid
+	}
+
+	if info.PublishCid == "" {
+		published, err := t.publishInfoFromProvider(ctx, deal, info)
+		if err != nil {
+			return nil, err
+		}
+		if !published {
+			return info, nil
+		}
+	}
+
+	if info.ChainDealID == 0 {
+		dealID, err := t.resolveDealID(ctx, deal, info.PublishCid)
+		if err != nil {
+			return nil, err
+		}
+		if dealID == 0 {
+			// the publish message has not landed on chain yet
+			return info, t.db.SetChainInfo(ctx, info)
+		}
+		info.ChainDealID = uint64(dealID)
+	}
+
+	head, err := t.fullNode.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get chain head: %w", err)
+	}
+
+	state, message := DealStatePublishConfirmed, fmt.Sprintf("chain deal id %d", info.ChainDealID)
+	md, err := t.fullNode.StateMarketStorageDeal(ctx, abi.DealID(info.ChainDealID), chaintypes.EmptyTSK)
+	switch {
+	case err != nil && strings.Contains(err.Error(), "not found"):
+		// the market actor removes deals that expired, or that were not
+		// activated before their start epoch
+		if head.Height() <= abi.ChainEpoch(deal.StartEpoch) {
+			return nil, fmt.Errorf("dealUuid: %s, chain deal %d not found: %w", deal.ID, info.ChainDealID, err)
+		}
+		state = DealStateExpired
+		if info.SectorStartEpoch < 0 {
+			message = fmt.Sprintf("chain deal id %d was not activated before start epoch %d", info.ChainDealID, deal.StartEpoch)
+		} else {
+			message = fmt.Sprintf("chain deal id %d expired at epoch %d", info.ChainDealID, deal.EndEpoch)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("dealUuid: %s, getting chain deal %d: %w", deal.ID, info.ChainDealID, err)
+	default:
+		info.SectorStartEpoch = int64(md.State.SectorStartEpoch)
+		info.SlashEpoch = int64(md.State.SlashEpoch)
+		switch {
+		case md.State.SlashEpoch > -1:
+			state = DealStateSlashed
+			message = fmt.Sprintf("chain deal id %d slashed at epoch %d", info.ChainDealID, md.State.SlashEpoch)
+		case head.Height() > md.Proposal.EndEpoch:
+			state = DealStateExpired
+			message = fmt.Sprintf("chain deal id %d expired at epoch %d", info.ChainDealID, md.Proposal.EndEpoch)
+		case md.State.SectorStartEpoch > -1:
+			state = DealStateActive
+			message = fmt.Sprintf("chain deal id %d active since epoch %d in sector %d", info.ChainDealID, md.State.SectorStartEpoch, md.State.SectorNumber)
+		}
+	}
+
+	if err := t.db.SetChainInfo(ctx, info); err != nil {
+		return nil, err
+	}
+	if err := t.db.UpdateState(ctx, deal.ID, state, message); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// publishInfoFromProvider asks the provider for the publish message cid of the
+// deal, and for the chain deal id if the provider has already resolved it
+func (t *dealTracker) publishInfoFromProvider(ctx context.Context, deal *DealRecord, info *DealChainInfo) (bool, error) {
+	if t.node == nil {
+		n, err := t.client.setupNode()
+		if err != nil {
+			return false, err
+		}
+		t.node = n
+	}
+
+	resp, err := queryDealStatus(ctx, t.node, t.client.nodeSigner(t.node), t.fullNode, deal)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.db.UpdateState(ctx, deal.ID, resp.DealStatus.Status, resp.DealStatus.Error); err != nil {
+		return false, err
+	}
+
+	if resp.DealStatus.PublishCid == nil {
+		return false, nil
+	}
+	info.PublishCid = resp.DealStatus.PublishCid.String()
+	info.ChainDealID = uint64(resp.DealStatus.ChainDealID)
+	return true, nil
+}
+
+// resolveDealID finds the publish message on chain and matches the deal
+// proposal against the deal ids returned by the market actor. It returns 0 if
+// the message has not been included in a block yet.
+func (t *dealTracker) resolveDealID(ctx context.Context, deal *DealRecord, publishCidStr string) (abi.DealID, error) {
+	publishCid, err := cid.Parse(publishCidStr)
+	if err != nil {
+		return 0, fmt.Errorf("parsing publish cid %s: %w", publishCidStr, err)
+	}
+
+	lookup, err := t.fullNode.StateSearchMsg(ctx, chaintypes.EmptyTSK, publishCid, api.LookbackNoLimit, true)
+	if err != nil {
+		return 0, fmt.Errorf("searching publish message %s: %w", publishCid, err)
+	}
+	if lookup == nil {
+		return 0, nil
+	}
+
+	if lookup.Receipt.ExitCode.IsError() {
+		return 0, fmt.Errorf("publish message %s failed with exit code %s", lookup.Message, lookup.Receipt.ExitCode)
+	}
+
+	msg, err := t.fullNode.ChainGetMessage(ctx, lookup.Message)
+	if err != nil {
+		return 0, fmt.Errorf("getting publish message %s: %w", lookup.Message, err)
+	}
+
+	var params market.PublishStorageDealsParams
+	if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return 0, fmt.Errorf("decoding publish message params: %w", err)
+	}
+
+	index := -1
+	for i, d := range params.Deals {
+		proposalCid, err := d.Proposal.Cid()
+		if err != nil {
+			return 0, err
+		}
+		if proposalCid.String() == deal.ProposalCid {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("dealUuid: %s, proposal %s not found in publish message %s", deal.ID, deal.ProposalCid, lookup.Message)
+	}
+
+	nv, err := t.fullNode.StateNetworkVersion(ctx, lookup.TipSet)
+	if err != nil {
+		return 0, fmt.Errorf("getting network version: %w", err)
+	}
+
+	ret, err := lmarket.DecodePublishStorageDealsReturn(lookup.Receipt.Return, nv)
+	if err != nil {
+		return 0, fmt.Errorf("decoding publish message return: %w", err)
+	}
+
+	valid, outIdx, err := ret.IsDealValid(uint64(index))
+	if err != nil {
+		return 0, err
+	}
+	if !valid {
+		return 0, fmt.Errorf("dealUuid: %s, proposal was rejected by the market actor in publish message %s", deal.ID, lookup.Message)
+	}
+
+	dealIDs, err := ret.DealIDs()
+	if err != nil {
+		return 0, err
+	}
+	if outIdx >= len(dealIDs) {
+		return 0, fmt.Errorf("dealUuid: %s, deal index %d out of range of published deal ids", deal.ID, outIdx)
+	}
+
+	logs.GetLogger().Infof("dealUuid: %s, published in message %s with chain deal id %d", deal.ID, lookup.Message, dealIDs[outIdx])
+	return dealIDs[outIdx], nil
+}
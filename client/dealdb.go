@@ -0,0 +1,351 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/google/uuid"
+	"github.com/mitchellh/go-homedir"
+)
+
+const DealDBName = "client.db"
+
+// Local deal states recorded by the client. Once the provider reports the
+// deal status, its checkpoint name (e.g. Transferred, Published) is recorded.
+const (
+	DealStateProposed = "Proposed"
+	DealStateAccepted = "Accepted"
+	DealStateRejected = "Rejected"
+	DealStateError    = "Error"
+
+	// states resolved from chain state by the deal tracker
+	DealStatePublishConfirmed = "PublishConfirmed"
+	DealStateActive           = "Active"
+	DealStateSlashed          = "Slashed"
+	DealStateExpired          = "Expired"
+)
+
+var createDealDBSQL = `
+CREATE TABLE IF NOT EXISTS ClientDeals (
+          ID                 TEXT PRIMARY KEY,
+          CreatedAt          DateTime,
+          UpdatedAt          DateTime,
+          Provider           TEXT,
+          Wallet             TEXT,
+          PieceCid           TEXT,
+          PieceSize          INT,
+          PayloadCid         TEXT,
+          CarSize            INT,
+          StartEpoch         INT,
+          EndEpoch           INT,
+          ProposedEpoch      INT,
+          StoragePrice       TEXT,
+          ProviderCollateral TEXT,
+          ClientCollateral   TEXT,
+          Verified           BOOL,
+          ProposalCid        TEXT,
+          Label              TEXT,
+          State              TEXT,
+          Message            TEXT
+);
+
+CREATE INDEX IF NOT EXISTS index_client_deals_provider on ClientDeals(Provider);
+CREATE INDEX IF NOT EXISTS index_client_deals_piece_cid on ClientDeals(PieceCid);
+CREATE INDEX IF NOT EXISTS index_client_deals_state on ClientDeals(State);
+CREATE INDEX IF NOT EXISTS index_client_deals_label on ClientDeals(Label);
+CREATE INDEX IF NOT EXISTS index_client_deals_created_at on ClientDeals(CreatedAt);
+
+CREATE TABLE IF NOT EXISTS ClientDealStatus (
+          DealID    TEXT,
+          CreatedAt DateTime,
+          State     TEXT,
+          Message   TEXT
+);
+
+CREATE INDEX IF NOT EXISTS index_client_deal_status_deal_id on ClientDealStatus(DealID);
+
+CREATE TABLE IF NOT EXISTS ClientDealChain (
+          DealID           TEXT PRIMARY KEY,
+          PublishCid       TEXT,
+          ChainDealID      INT,
+          SectorStartEpoch INT,
+          SlashEpoch       INT,
+          UpdatedAt        DateTime
+);
+`
+
+// DealRecord is a deal proposal sent by the client, as stored in the client repo
+type DealRecord struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Provider           string
+	Wallet             string
+	PieceCid           string
+	PieceSize          uint64
+	PayloadCid         string
+	CarSize            uint64
+	StartEpoch         int64
+	EndEpoch           int64
+	ProposedEpoch      int64  // chain head when the proposal was created
+	StoragePrice       string // total storage price per epoch in attoFIL
+	ProviderCollateral string
+	ClientCollateral   string
+	Verified           bool
+	ProposalCid        string
+	Label              string // string label of the proposal, or its bytes label hex encoded
+	State              string
+	Message            string
+}
+
+// DealStatusUpdate is a single entry of the state history of a deal
+type DealStatusUpdate struct {
+	DealID    uuid.UUID
+	CreatedAt time.Time
+	State     string
+	Message   string
+}
+
+// DealChainInfo is the on-chain state of a deal, as resolved by the deal tracker
+type DealChainInfo struct {
+	DealID           uuid.UUID
+	PublishCid       string
+	ChainDealID      uint64 // 0 until the publish message has been matched
+	SectorStartEpoch int64  // -1 until the deal is active
+	SlashEpoch       int64  // -1 unless the deal was slashed
+	UpdatedAt        time.Time
+}
+
+type DealDB struct {
+	db *sql.DB
+}
+
+// NewDealDB opens the deal database in the client repo, creating the tables if
+// needed. Each handle has its own connection, and concurrent writers through
+// different handles wait on the sqlite file lock.
+func NewDealDB(repo string) (*DealDB, error) {
+	repoPath, err := homedir.Expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := path.Join(repoPath, DealDBName)
+	d, err := db.SqlDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.ExecContext(context.TODO(), createDealDBSQL); err != nil {
+		d.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to create tables in deal DB: %w", err)
+	}
+	return &DealDB{db: d}, nil
+}
+
+// DealDB opens the deal database in the client repo
+func (client *Client) DealDB() (*DealDB, error) {
+	return NewDealDB(client.ClientRepo)
+}
+
+func (d *DealDB) Close() error {
+	return d.db.Close()
+}
+
+// Insert records a new deal proposal and the first entry of its state history
+func (d *DealDB) Insert(ctx context.Context, deal *DealRecord) error {
+	now := time.Now()
+	if deal.CreatedAt.IsZero() {
+		deal.CreatedAt = now
+	}
+	deal.UpdatedAt = deal.CreatedAt
+
+	qry := "INSERT INTO ClientDeals (ID, CreatedAt, UpdatedAt, Provider, Wallet, PieceCid, PieceSize, PayloadCid, CarSize, StartEpoch, EndEpoch, ProposedEpoch, "
+	qry += "StoragePrice, ProviderCollateral, ClientCollateral, Verified, ProposalCid, Label, State, Message) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	values := []interface{}{deal.ID.String(), deal.CreatedAt, deal.UpdatedAt, deal.Provider, deal.Wallet, deal.PieceCid, deal.PieceSize, deal.PayloadCid, deal.CarSize,
+		deal.StartEpoch, deal.EndEpoch, deal.ProposedEpoch, deal.StoragePrice, deal.ProviderCollateral, deal.ClientCollateral, deal.Verified, deal.ProposalCid,
+		deal.Label, deal.State, deal.Message}
+	if _, err := d.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("inserting deal %s: %w", deal.ID, err)
+	}
+	return d.addStatus(ctx, deal.ID, deal.CreatedAt, deal.State, deal.Message)
+}
+
+// UpdateState sets the current state of a deal and appends it to the deal's
+// state history. Repeating the current state and message is a no-op.
+func (d *DealDB) UpdateState(ctx context.Context, id uuid.UUID, state, message string) error {
+	deal, err := d.ByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if deal.State == state && deal.Message == message {
+		return nil
+	}
+
+	now := time.Now()
+	qry := "UPDATE ClientDeals SET State=?, Message=?, UpdatedAt=? WHERE ID=?"
+	if _, err := d.db.ExecContext(ctx, qry, state, message, now, id.String()); err != nil {
+		return fmt.Errorf("updating state of deal %s: %w", id, err)
+	}
+	return d.addStatus(ctx, id, now, state, message)
+}
+
+func (d *DealDB) addStatus(ctx context.Context, id uuid.UUID, at time.Time, state, message string) error {
+	qry := "INSERT INTO ClientDealStatus (DealID, CreatedAt, State, Message) VALUES (?, ?, ?, ?)"
+	if _, err := d.db.ExecContext(ctx, qry, id.String(), at, state, message); err != nil {
+		return fmt.Errorf("recording state of deal %s: %w", id, err)
+	}
+	return nil
+}
+
+const dealFields = "ID, CreatedAt, UpdatedAt, Provider, Wallet, PieceCid, PieceSize, PayloadCid, CarSize, StartEpoch, EndEpoch, ProposedEpoch, " +
+	"StoragePrice, ProviderCollateral, ClientCollateral, Verified, ProposalCid, Label, State, Message"
+
+// ByID returns the deal with the given uuid
+func (d *DealDB) ByID(ctx context.Context, id uuid.UUID) (*DealRecord, error) {
+	qry := "SELECT " + dealFields + " FROM ClientDeals WHERE ID=?"
+	row := d.db.QueryRowContext(ctx, qry, id.String())
+	deal, err := scanDeal(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("deal %s: %w", id, db.ErrNotFound)
+	}
+	return deal, err
+}
+
+// ListByProvider returns all deals sent to the given storage provider
+func (d *DealDB) ListByProvider(ctx context.Context, provider string) ([]*DealRecord, error) {
+	return d.list(ctx, "WHERE Provider=?", provider)
+}
+
+// ListByPieceCid returns all deals made for the given piece
+func (d *DealDB) ListByPieceCid(ctx context.Context, pieceCid string) ([]*DealRecord, error) {
+	return d.list(ctx, "WHERE PieceCid=?", pieceCid)
+}
+
+// ListByLabel returns all deals proposed with the given label, as stored in
+// DealRecord.Label
+func (d *DealDB) ListByLabel(ctx context.Context, label string) ([]*DealRecord, error) {
+	return d.list(ctx, "WHERE Label=?", label)
+}
+
+// ListByState returns all deals currently in the given state
+func (d *DealDB) ListByState(ctx context.Context, state string) ([]*DealRecord, error) {
+	return d.list(ctx, "WHERE State=?", state)
+}
+
+// ListByDate returns all deals proposed in the [from, to) time range
+func (d *DealDB) ListByDate(ctx context.Context, from, to time.Time) ([]*DealRecord, error) {
+	return d.list(ctx, "WHERE CreatedAt >= ? AND CreatedAt < ?", from, to)
+}
+
+// List returns all deals
+func (d *DealDB) List(ctx context.Context) ([]*DealRecord, error) {
+	return d.list(ctx, "")
+}
+
+func (d *DealDB) list(ctx context.Context, where string, args ...interface{}) ([]*DealRecord, error) {
+	qry := "SELECT " + dealFields + " FROM ClientDeals " + where + " ORDER BY CreatedAt"
+	rows, err := d.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deals []*DealRecord
+	for rows.Next() {
+		deal, err := scanDeal(rows)
+		if err != nil {
+			return nil, err
+		}
+		deals = append(deals, deal)
+	}
+	return deals, rows.Err()
+}
+
+// StatusHistory returns the state changes of a deal, oldest first
+func (d *DealDB) StatusHistory(ctx context.Context, id uuid.UUID) ([]DealStatusUpdate, error) {
+	qry := "SELECT CreatedAt, State, Message FROM ClientDealStatus WHERE DealID=? ORDER BY CreatedAt"
+	rows, err := d.db.QueryContext(ctx, qry, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []DealStatusUpdate
+	for rows.Next() {
+		update := DealStatusUpdate{DealID: id}
+		if err := rows.Scan(&update.CreatedAt, &update.State, &update.Message); err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, rows.Err()
+}
+
+func scanDeal(row db.Scannable) (*DealRecord, error) {
+	var deal DealRecord
+	var id string
+	err := row.Scan(&id, &deal.CreatedAt, &deal.UpdatedAt, &deal.Provider, &deal.Wallet, &deal.PieceCid, &deal.PieceSize, &deal.PayloadCid, &deal.CarSize,
+		&deal.StartEpoch, &deal.EndEpoch, &deal.ProposedEpoch, &deal.StoragePrice, &deal.ProviderCollateral, &deal.ClientCollateral, &deal.Verified,
+		&deal.ProposalCid, &deal.Label, &deal.State, &deal.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	deal.ID, err = uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("parsing deal uuid %s: %w", id, err)
+	}
+	return &deal, nil
+}
+
+// SetChainInfo stores the on-chain state of a deal
+func (d *DealDB) SetChainInfo(ctx context.Context, info *DealChainInfo) error {
+	info.UpdatedAt = time.Now()
+	qry := "INSERT OR REPLACE INTO ClientDealChain (DealID, PublishCid, ChainDealID, SectorStartEpoch, SlashEpoch, UpdatedAt) VALUES (?, ?, ?, ?, ?, ?)"
+	values := []interface{}{info.DealID.String(), info.PublishCid, info.ChainDealID, info.SectorStartEpoch, info.SlashEpoch, info.UpdatedAt}
+	if _, err := d.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("storing chain info of deal %s: %w", info.DealID, err)
+	}
+	return nil
+}
+
+// ChainInfo returns the on-chain state of a deal. If the deal has not been
+// tracked yet, an empty DealChainInfo is returned.
+func (d *DealDB) ChainInfo(ctx context.Context, id uuid.UUID) (*DealChainInfo, error) {
+	info := &DealChainInfo{DealID: id, SectorStartEpoch: -1, SlashEpoch: -1}
+	qry := "SELECT PublishCid, ChainDealID, SectorStartEpoch, SlashEpoch, UpdatedAt FROM ClientDealChain WHERE DealID=?"
+	row := d.db.QueryRowContext(ctx, qry, id.String())
+	err := row.Scan(&info.PublishCid, &info.ChainDealID, &info.SectorStartEpoch, &info.SlashEpoch, &info.UpdatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ActivationDelays returns the number of epochs between the proposal and the
+// activation of the most recent active deals with the provider, newest first
+func (d *DealDB) ActivationDelays(ctx context.Context, provider string, limit int) ([]int64, error) {
+	qry := "SELECT c.SectorStartEpoch - d.ProposedEpoch FROM ClientDeals d JOIN ClientDealChain c ON c.DealID = d.ID "
+	qry += "WHERE d.Provider=? AND d.ProposedEpoch > 0 AND c.SectorStartEpoch > d.ProposedEpoch ORDER BY d.CreatedAt DESC LIMIT ?"
+	rows, err := d.db.QueryContext(ctx, qry, provider, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var delays []int64
+	for rows.Next() {
+		var delay int64
+		if err := rows.Scan(&delay); err != nil {
+			return nil, err
+		}
+		delays = append(delays, delay)
+	}
+	return delays, rows.Err()
+}
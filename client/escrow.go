@@ -0,0 +1,304 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/storagemarket/types/dealcheckpoints"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/ipfs/go-cid"
+)
+
+// ErrInsufficientEscrow is returned when the available market escrow of the
+// client wallet cannot cover a deal
+var ErrInsufficientEscrow = errors.New("insufficient market escrow")
+
+type EscrowBalance struct {
+	Escrow    abi.TokenAmount
+	Locked    abi.TokenAmount
+	Available abi.TokenAmount // escrow that is neither locked on chain nor reserved by deals waiting to be published
+	Pending   abi.TokenAmount // escrow reserved by proposed or accepted deals that are not published yet
+}
+
+// escrowLocks serializes the escrow checks of a wallet with the proposals they
+// record, so that concurrent deals do not count the same available escrow
+var escrowLocks = struct {
+	sync.Mutex
+	wallets map[address.Address]*sync.Mutex
+}{wallets: make(map[address.Address]*sync.Mutex)}
+
+// lockEscrow locks the escrow of the wallet until the returned function is
+// called
+func lockEscrow(walletAddr address.Address) func() {
+	escrowLocks.Lock()
+	lk, ok := escrowLocks.wallets[walletAddr]
+	if !ok {
+		lk = new(sync.Mutex)
+		escrowLocks.wallets[walletAddr] = lk
+	}
+	escrowLocks.Unlock()
+
+	lk.Lock()
+	return lk.Unlock
+}
+
+// WithEscrowTopUp enables adding the missing escrow from the wallet balance
+// when a deal needs more escrow than is available
+func (client *Client) WithEscrowTopUp(enable bool) *Client {
+	client.autoTopUpEscrow = enable
+	return client
+}
+
+// DealEscrow returns the escrow a deal locks in the market actor: the storage
+// price over the deal duration plus the client collateral
+func DealEscrow(dealP DealParam) abi.TokenAmount {
+	pricePerEpoch := dealStoragePricePerEpoch(abi.PaddedPieceSize(dealP.PieceSize), tokenOrZero(dealP.StoragePrice))
+	clientCollateral, _ := market.DealClientCollateralBounds(abi.PaddedPieceSize(dealP.PieceSize), abi.ChainEpoch(dealP.Duration))
+	return big.Add(big.Mul(pricePerEpoch, big.NewInt(int64(dealP.Duration))), clientCollateral)
+}
+
+// EscrowForDeals returns the escrow a batch of deals locks in the market actor
+func EscrowForDeals(deals []DealParam) abi.TokenAmount {
+	total := big.Zero()
+	for _, dealP := range deals {
+		total = big.Add(total, DealEscrow(dealP))
+	}
+	return total
+}
+
+// MarketBalance returns the market escrow of the wallet
+func (client *Client) MarketBalance(walletAddress string) (*EscrowBalance, error) {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	return client.marketBalance(ctx, gapi, walletAddr)
+}
+
+func (client *Client) marketBalance(ctx context.Context, gapi api.Gateway, walletAddr address.Address) (*EscrowBalance, error) {
+	mbal, err := gapi.StateMarketBalance(ctx, walletAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("getting market balance of %s: %w", walletAddr, err)
+	}
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting chain head: %w", err)
+	}
+
+	pending, err := client.pendingEscrow(ctx, walletAddr, head.Height())
+	if err != nil {
+		return nil, err
+	}
+
+	available := big.Sub(big.Sub(mbal.Escrow, mbal.Locked), pending)
+	if available.LessThan(big.Zero()) {
+		available = big.Zero()
+	}
+	return &EscrowBalance{
+		Escrow:    mbal.Escrow,
+		Locked:    mbal.Locked,
+		Available: available,
+		Pending:   pending,
+	}, nil
+}
+
+// pendingEscrow sums the escrow of deals proposed to or accepted by providers
+// that has not been locked on chain yet, because the deals are not published.
+// Deals starting at or before the head can no longer be published.
+func (client *Client) pendingEscrow(ctx context.Context, walletAddr address.Address, head abi.ChainEpoch) (abi.TokenAmount, error) {
+	dealDB, err := client.DealDB()
+	if err != nil {
+		return abi.TokenAmount{}, fmt.Errorf("opening deal db: %w", err)
+	}
+	defer dealDB.Close()
+
+	deals, err := dealDB.List(ctx)
+	if err != nil {
+		return abi.TokenAmount{}, err
+	}
+
+	pending := big.Zero()
+	for _, deal := range deals {
+		if deal.Wallet != walletAddr.String() || abi.ChainEpoch(deal.StartEpoch) <= head {
+			continue
+		}
+		switch deal.State {
+		case DealStateProposed, dealcheckpoints.Accepted.String(), dealcheckpoints.Transferred.String(), dealcheckpoints.Published.String():
+		default:
+			continue
+		}
+
+		pricePerEpoch, err := parseToken(deal.StoragePrice)
+		if err != nil || pricePerEpoch.Nil() {
+			continue
+		}
+		escrow := big.Mul(pricePerEpoch, big.NewInt(deal.EndEpoch-deal.StartEpoch))
+		if clientCollateral, err := parseToken(deal.ClientCollateral); err == nil && !clientCollateral.Nil() {
+			escrow = big.Add(escrow, clientCollateral)
+		}
+		pending = big.Add(pending, escrow)
+	}
+	return pending, nil
+}
+
+// MarketAddBalance moves funds from the wallet balance to its market escrow
+// and waits for the message to land on chain
+func (client *Client) MarketAddBalance(walletAddress string, amount abi.TokenAmount, assumeYes bool) (cid.Cid, error) {
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	params, err := actors.SerializeParams(&walletAddr)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("serializing add balance params: %w", err)
+	}
+
+	return client.pushMarketMessage(&chaintypes.Message{
+		To:     builtin.StorageMarketActorAddr,
+		From:   walletAddr,
+		Value:  amount,
+		Method: builtin.MethodsMarket.AddBalance,
+		Params: params,
+	}, assumeYes)
+}
+
+// MarketWithdrawBalance moves available funds from the market escrow back to
+// the wallet and waits for the message to land on chain
+func (client *Client) MarketWithdrawBalance(walletAddress string, amount abi.TokenAmount, assumeYes bool) (cid.Cid, error) {
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	params, err := actors.SerializeParams(&market.WithdrawBalanceParams{
+		ProviderOrClientAddress: walletAddr,
+		Amount:                  amount,
+	})
+	if err != nil {
+		return cid.Undef, fmt.Errorf("serializing withdraw balance params: %w", err)
+	}
+
+	return client.pushMarketMessage(&chaintypes.Message{
+		To:     builtin.StorageMarketActorAddr,
+		From:   walletAddr,
+		Value:  big.Zero(),
+		Method: builtin.MethodsMarket.WithdrawBalance,
+		Params: params,
+	}, assumeYes)
+}
+
+func (client *Client) pushMarketMessage(msg *chaintypes.Message, assumeYes bool) (cid.Cid, error) {
+	ctx := context.Background()
+	n, err := client.setupNode()
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer n.Host.Close()
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer closer()
+
+	mcids, err := client.pushMessages(ctx, gapi, n, []*chaintypes.Message{msg}, assumeYes)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !mcids[0].Defined() {
+		return cid.Undef, fmt.Errorf("market message was not sent")
+	}
+
+	if err := client.waitMessages(ctx, gapi, mcids); err != nil {
+		return mcids[0], err
+	}
+	return mcids[0], nil
+}
+
+// CheckEscrow is a preflight check that the wallet has enough available
+// escrow for deals requiring the given amount. If the escrow top up is enabled
+// the missing amount is added from the wallet balance, otherwise
+// ErrInsufficientEscrow is returned.
+func (client *Client) CheckEscrow(walletAddress string, required abi.TokenAmount) error {
+	ctx := context.Background()
+	walletAddr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return err
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return err
+	}
+	defer n.Host.Close()
+
+	unlock := lockEscrow(walletAddr)
+	defer unlock()
+	return client.ensureEscrow(ctx, n, walletAddr, required)
+}
+
+// ensureEscrow must be called with the escrow of the wallet locked
+func (client *Client) ensureEscrow(ctx context.Context, n *clinode.Node, walletAddr address.Address, required abi.TokenAmount) error {
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	bal, err := client.marketBalance(ctx, gapi, walletAddr)
+	if err != nil {
+		return err
+	}
+
+	if bal.Available.GreaterThanEqual(required) {
+		return nil
+	}
+
+	missing := big.Sub(required, bal.Available)
+	if !client.autoTopUpEscrow {
+		return fmt.Errorf("%w: wallet %s needs %s, available %s (escrow %s, locked %s, pending %s), please add %s to the market escrow",
+			ErrInsufficientEscrow, walletAddr, chaintypes.FIL(required), chaintypes.FIL(bal.Available), chaintypes.FIL(bal.Escrow),
+			chaintypes.FIL(bal.Locked), chaintypes.FIL(bal.Pending), chaintypes.FIL(missing))
+	}
+
+	logs.GetLogger().Info("wallet ", walletAddr, " is missing ", chaintypes.FIL(missing), " of market escrow, adding it from the wallet balance")
+
+	params, err := actors.SerializeParams(&walletAddr)
+	if err != nil {
+		return fmt.Errorf("serializing add balance params: %w", err)
+	}
+
+	msg := &chaintypes.Message{
+		To:     builtin.StorageMarketActorAddr,
+		From:   walletAddr,
+		Value:  missing,
+		Method: builtin.MethodsMarket.AddBalance,
+		Params: params,
+	}
+	mcids, err := client.pushMessages(ctx, gapi, n, []*chaintypes.Message{msg}, true)
+	if err != nil {
+		return fmt.Errorf("adding market escrow: %w", err)
+	}
+	return client.waitMessages(ctx, gapi, mcids)
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// NewExportKey creates a key pair to receive encrypted wallet exports. The
+// public key is given to WalletExportEncrypted, the private key to
+// WalletImportEncrypted; both are hex encoded.
+func NewExportKey() (publicKey string, privateKey string, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(pub[:]), hex.EncodeToString(priv[:]), nil
+}
+
+// WalletExportEncrypted prints the key of the wallet encrypted to the public
+// key of the recipient, as hex
+func (client *Client) WalletExportEncrypted(walletAddress, recipient string) error {
+	ctx := context.Background()
+	pub, err := parseExportKey(recipient)
+	if err != nil {
+		return fmt.Errorf("parsing recipient key: %w", err)
+	}
+
+	ki, err := client.walletExport(ctx, walletAddress)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(ki)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := box.SealAnonymous(nil, b, pub, rand.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(sealed))
+	return nil
+}
+
+// WalletImportEncrypted imports a key exported with WalletExportEncrypted,
+// decrypting it with the private key of the recipient
+func (client *Client) WalletImportEncrypted(inputData []byte, recipientPrivateKey string) error {
+	ctx := context.Background()
+	priv, err := parseExportKey(recipientPrivateKey)
+	if err != nil {
+		return fmt.Errorf("parsing recipient private key: %w", err)
+	}
+	pubKey, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	var pub [32]byte
+	copy(pub[:], pubKey)
+
+	sealed, err := hex.DecodeString(strings.TrimSpace(string(inputData)))
+	if err != nil {
+		return err
+	}
+	data, ok := box.OpenAnonymous(nil, sealed, &pub, priv)
+	if !ok {
+		return errors.New("decrypting wallet export failed, wrong recipient key")
+	}
+
+	var ki chaintypes.KeyInfo
+	if err := json.Unmarshal(data, &ki); err != nil {
+		return err
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return err
+	}
+	defer n.Host.Close()
+
+	if _, err := n.Wallet.WalletImport(ctx, &ki); err != nil {
+		logs.GetLogger().Error("wallet import failed: %w", err)
+		return err
+	}
+	logs.GetLogger().Infof("wallet import successfully")
+	return nil
+}
+
+func (client *Client) walletExport(ctx context.Context, walletAddress string) (*chaintypes.KeyInfo, error) {
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	addr, err := ParseAddress(walletAddress)
+	if err != nil {
+		return nil, err
+	}
+	return n.Wallet.WalletExport(ctx, addr)
+}
+
+func parseExportKey(s string) (*[32]byte, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(b))
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
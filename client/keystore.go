@@ -0,0 +1,422 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/boost/lib/keystore"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
+	"github.com/libp2p/go-libp2p"
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/mitchellh/go-homedir"
+	"github.com/whyrusleeping/base32"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KeystoreCryptoFile holds the key derivation parameters of an encrypted
+	// keystore, in the client repo
+	KeystoreCryptoFile = "wallet.crypto.json"
+
+	keystoreCheckText = "swan-boost-lib keystore"
+)
+
+// encryptedKeyPrefix marks the private keys encrypted in the keystore
+var encryptedKeyPrefix = []byte("swanenc1:")
+
+// ErrKeystoreLocked is returned when a key is needed from an encrypted
+// keystore that has not been unlocked
+var ErrKeystoreLocked = errors.New("keystore is locked, unlock it with the passphrase")
+
+// ErrWrongPassphrase is returned when unlocking a keystore with a wrong passphrase
+var ErrWrongPassphrase = errors.New("wrong keystore passphrase")
+
+// keystoreCrypto are the parameters of the key encrypting the private keys of
+// the keystore, derived from the passphrase with scrypt
+type keystoreCrypto struct {
+	Salt  []byte `json:"salt"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Check []byte `json:"check"` // keystoreCheckText sealed with the key, to check the passphrase
+}
+
+func (kc *keystoreCrypto) deriveKey(passphrase []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, kc.Salt, kc.N, kc.R, kc.P, 32)
+}
+
+// KeystoreEncrypted reports whether the keystore of the client repo is encrypted
+func (client *Client) KeystoreEncrypted() (bool, error) {
+	kc, err := client.readKeystoreCrypto()
+	return kc != nil, err
+}
+
+// EncryptKeystore encrypts the private keys of the keystore in the client
+// repo with the passphrase, and unlocks it
+func (client *Client) EncryptKeystore(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("the passphrase cannot be empty")
+	}
+	kc, err := client.readKeystoreCrypto()
+	if err != nil {
+		return err
+	}
+	if kc != nil {
+		return errors.New("the keystore is already encrypted")
+	}
+
+	kc = &keystoreCrypto{N: 1 << 18, R: 8, P: 1, Salt: make([]byte, 32)}
+	if _, err := rand.Read(kc.Salt); err != nil {
+		return err
+	}
+	key, err := kc.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if kc.Check, err = seal(key, []byte(keystoreCheckText)); err != nil {
+		return err
+	}
+
+	// encrypt the keys before writing the parameters, so that a failure leaves
+	// a plain keystore behind
+	ks, err := client.openKeystore(key)
+	if err != nil {
+		return err
+	}
+	if err := ks.encryptPlainKeys(); err != nil {
+		return err
+	}
+
+	path, err := client.repoFile(KeystoreCryptoFile)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(kc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	client.keystoreKey = key
+	return nil
+}
+
+// UnlockKeystore unlocks the encrypted keystore for signing, until
+// LockKeystore is called
+func (client *Client) UnlockKeystore(passphrase []byte) error {
+	kc, err := client.readKeystoreCrypto()
+	if err != nil {
+		return err
+	}
+	if kc == nil {
+		return errors.New("the keystore is not encrypted")
+	}
+
+	key, err := kc.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	check, err := open(key, kc.Check)
+	if err != nil || string(check) != keystoreCheckText {
+		return ErrWrongPassphrase
+	}
+	client.keystoreKey = key
+
+	// keys added by tools using the plain keystore are encrypted on unlock
+	ks, err := client.openKeystore(key)
+	if err != nil {
+		return err
+	}
+	return ks.encryptPlainKeys()
+}
+
+// UnlockKeystoreFile unlocks the encrypted keystore with the passphrase in a
+// key file
+func (client *Client) UnlockKeystoreFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return client.UnlockKeystore(bytes.TrimSpace(data))
+}
+
+// LockKeystore forgets the key of the encrypted keystore
+func (client *Client) LockKeystore() {
+	for i := range client.keystoreKey {
+		client.keystoreKey[i] = 0
+	}
+	client.keystoreKey = nil
+}
+
+// setupNode sets up the client node, with a wallet backed by the encrypted
+// keystore if the keystore of the client repo is encrypted
+func (client *Client) setupNode() (*clinode.Node, error) {
+	kc, err := client.readKeystoreCrypto()
+	if err != nil {
+		return nil, err
+	}
+	if kc == nil {
+		return clinode.Setup(client.ClientRepo)
+	}
+
+	// clinode.Setup creates a plain key in an empty keystore, so the node of
+	// an encrypted repo is set up here
+	repoPath, err := homedir.Expand(client.ClientRepo)
+	if err != nil {
+		return nil, fmt.Errorf("getting homedir: %w", err)
+	}
+	if _, err := os.Stat(repoPath); err != nil {
+		return nil, fmt.Errorf("opening client repo %s: %w", repoPath, err)
+	}
+
+	ks, err := client.openKeystore(client.keystoreKey)
+	if err != nil {
+		return nil, err
+	}
+	w, err := wallet.NewWallet(ks)
+	if err != nil {
+		return nil, err
+	}
+	if ks.key != nil {
+		addrs, err := w.WalletList(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			if _, err := w.WalletNew(context.TODO(), chaintypes.KTBLS); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	peerKey, err := loadOrInitPeerKey(filepath.Join(repoPath, "libp2p.key"))
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(
+		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
+		libp2p.Identity(peerKey),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &clinode.Node{Host: h, Wallet: w}, nil
+}
+
+// loadOrInitPeerKey reads the libp2p key of the client repo, as clinode.Setup
+// does
+func loadOrInitPeerKey(path string) (p2pcrypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return p2pcrypto.UnmarshalPrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	k, _, err := p2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = p2pcrypto.MarshalPrivateKey(k); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (client *Client) repoFile(name string) (string, error) {
+	repoPath, err := homedir.Expand(client.ClientRepo)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(repoPath, name), nil
+}
+
+func (client *Client) readKeystoreCrypto() (*keystoreCrypto, error) {
+	path, err := client.repoFile(KeystoreCryptoFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kc keystoreCrypto
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &kc, nil
+}
+
+func (client *Client) openKeystore(key []byte) (*encryptedKeystore, error) {
+	path, err := client.repoFile("wallet")
+	if err != nil {
+		return nil, err
+	}
+	ks, err := keystore.OpenOrInitKeystore(path)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedKeystore{disk: ks, path: path, key: key}, nil
+}
+
+// encryptedKeystore stores the private keys of the wallet encrypted in the
+// keystore on disk. Keys can be listed while it is locked.
+type encryptedKeystore struct {
+	disk chaintypes.KeyStore
+	path string // directory of the disk keystore
+	key  []byte // nil while the keystore is locked
+}
+
+var _ chaintypes.KeyStore = (*encryptedKeystore)(nil)
+
+func (ks *encryptedKeystore) List() ([]string, error) {
+	return ks.disk.List()
+}
+
+func (ks *encryptedKeystore) Get(name string) (chaintypes.KeyInfo, error) {
+	ki, err := ks.disk.Get(name)
+	if err != nil {
+		return ki, err
+	}
+	if !bytes.HasPrefix(ki.PrivateKey, encryptedKeyPrefix) {
+		return ki, nil
+	}
+	if ks.key == nil {
+		return chaintypes.KeyInfo{}, ErrKeystoreLocked
+	}
+	ki.PrivateKey, err = open(ks.key, ki.PrivateKey[len(encryptedKeyPrefix):])
+	if err != nil {
+		return chaintypes.KeyInfo{}, fmt.Errorf("decrypting key %s: %w", name, err)
+	}
+	return ki, nil
+}
+
+func (ks *encryptedKeystore) Put(name string, ki chaintypes.KeyInfo) error {
+	sealed, err := ks.sealKey(ki)
+	if err != nil {
+		return err
+	}
+	return ks.disk.Put(name, sealed)
+}
+
+func (ks *encryptedKeystore) sealKey(ki chaintypes.KeyInfo) (chaintypes.KeyInfo, error) {
+	if ks.key == nil {
+		return chaintypes.KeyInfo{}, ErrKeystoreLocked
+	}
+	sealed, err := seal(ks.key, ki.PrivateKey)
+	if err != nil {
+		return chaintypes.KeyInfo{}, err
+	}
+	ki.PrivateKey = append(append([]byte(nil), encryptedKeyPrefix...), sealed...)
+	return ki, nil
+}
+
+func (ks *encryptedKeystore) Delete(name string) error {
+	return ks.disk.Delete(name)
+}
+
+// encryptPlainKeys encrypts the keys that are stored in plain text
+func (ks *encryptedKeystore) encryptPlainKeys() error {
+	names, err := ks.disk.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		ki, err := ks.disk.Get(name)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(ki.PrivateKey, encryptedKeyPrefix) {
+			continue
+		}
+		sealed, err := ks.sealKey(ki)
+		if err != nil {
+			return fmt.Errorf("encrypting key %s: %w", name, err)
+		}
+		if err := ks.replaceKey(name, sealed); err != nil {
+			return fmt.Errorf("encrypting key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// replaceKey overwrites a key of the disk keystore, which only adds new keys.
+// The new key is written next to the keystore and renamed over the old one, so
+// that one of them is always on disk.
+func (ks *encryptedKeystore) replaceKey(name string, ki chaintypes.KeyInfo) error {
+	data, err := json.Marshal(ki)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".wallet-key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // gone after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// file name of the key in the disk keystore
+	keyPath := filepath.Join(ks.path, base32.RawStdEncoding.EncodeToString([]byte(name)))
+	return os.Rename(tmp.Name(), keyPath)
+}
+
+// seal encrypts the data with AES-GCM, prefixed with the nonce
+func seal(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
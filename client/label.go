@@ -0,0 +1,52 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+)
+
+// Label modes of a deal proposal
+const (
+	LabelModePayloadCid = "payload-cid" // the payload cid as a string label, the default
+	LabelModeString     = "string"      // DealParam.Label as a string label, e.g. a job id of the client
+	LabelModeBytes      = "bytes"       // DealParam.Label decoded from hex as a bytes label
+)
+
+// dealLabel returns the label of the deal proposal, and the text stored as
+// the label in the deal db: the string label, or the hex encoded bytes label
+func dealLabel(dealP DealParam) (market.DealLabel, string, error) {
+	switch dealP.LabelMode {
+	case "", LabelModePayloadCid:
+		if dealP.Label != "" {
+			return market.EmptyDealLabel, "", fmt.Errorf("a label can't be set with label mode %s", LabelModePayloadCid)
+		}
+		l, err := market.NewLabelFromString(dealP.PayloadCid)
+		return l, dealP.PayloadCid, err
+	case LabelModeString:
+		if len(dealP.Label) > market.DealMaxLabelSize {
+			return market.EmptyDealLabel, "", fmt.Errorf("label of %d bytes is longer than the max label size of %d bytes", len(dealP.Label), market.DealMaxLabelSize)
+		}
+		l, err := market.NewLabelFromString(dealP.Label)
+		if err != nil {
+			return market.EmptyDealLabel, "", fmt.Errorf("invalid string label: %w", err)
+		}
+		return l, dealP.Label, nil
+	case LabelModeBytes:
+		b, err := hex.DecodeString(dealP.Label)
+		if err != nil {
+			return market.EmptyDealLabel, "", fmt.Errorf("bytes label must be hex encoded: %w", err)
+		}
+		if len(b) > market.DealMaxLabelSize {
+			return market.EmptyDealLabel, "", fmt.Errorf("label of %d bytes is longer than the max label size of %d bytes", len(b), market.DealMaxLabelSize)
+		}
+		l, err := market.NewLabelFromBytes(b)
+		if err != nil {
+			return market.EmptyDealLabel, "", fmt.Errorf("invalid bytes label: %w", err)
+		}
+		return l, hex.EncodeToString(b), nil
+	default:
+		return market.EmptyDealLabel, "", fmt.Errorf("unknown label mode %q", dealP.LabelMode)
+	}
+}
@@ -0,0 +1,471 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	cliutil "github.com/filecoin-project/boost/cli/util"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	apiclient "github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/chain/messagepool"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/manifoldco/promptui"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultMessageConfidence = 1
+	DefaultMessageLookback   = abi.ChainEpoch(2000)
+)
+
+// defaultEstimateMaxFee is the max fee passed to the gas estimation when no
+// MaxFee is set
+var defaultEstimateMaxFee = abi.NewTokenAmount(1000000000) // 1 nFIL
+
+// MessageOptions control the fees of the messages the client sends and how
+// long it waits for them to land on chain
+type MessageOptions struct {
+	MaxFee     abi.TokenAmount // maximum fee of a single message, GasFeeCap * GasLimit; unlimited if nil
+	GasPremium abi.TokenAmount // gas premium of the messages; estimated if nil
+	Confidence uint64          // epochs to wait after the message is included. default 1
+	Lookback   abi.ChainEpoch  // how far back to look for the message on chain. default 2000
+	Timeout    time.Duration   // how long to wait for a message to land on chain; no timeout if 0
+}
+
+// WithMessageOptions sets the fee limits and the wait parameters of the
+// messages sent by the client
+func (client *Client) WithMessageOptions(opts MessageOptions) *Client {
+	client.msgOpts = opts
+	return client
+}
+
+func (opts MessageOptions) confidence() uint64 {
+	if opts.Confidence == 0 {
+		return DefaultMessageConfidence
+	}
+	return opts.Confidence
+}
+
+func (opts MessageOptions) lookback() abi.ChainEpoch {
+	if opts.Lookback <= 0 {
+		return DefaultMessageLookback
+	}
+	return opts.Lookback
+}
+
+func (client *Client) getGatewayApi(ctx context.Context) (api.Gateway, jsonrpc.ClientCloser, error) {
+	apiInfo := cliutil.ParseApiInfo(client.FullNodeApi)
+	addr, err := apiInfo.DialArgs("v1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse fullNodeApi failed: %w", err)
+	}
+	gapi, closer, err := apiclient.NewGatewayRPCV1(ctx, addr, apiInfo.AuthHeader())
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't setup gateway connection: %w", err)
+	}
+	return gapi, closer, nil
+}
+
+// estimateMessage fills in the gas of the message within the fee limits of
+// the options. The fee cap is at least 20% above the current base fee, unless
+// that exceeds MaxFee.
+func estimateMessage(ctx context.Context, gapi api.Gateway, msg *chaintypes.Message, opts MessageOptions) (*chaintypes.Message, error) {
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	basefee := head.Blocks()[0].ParentBaseFee
+
+	msg = msg.VMMessage()
+	if !opts.GasPremium.Nil() {
+		msg.GasPremium = opts.GasPremium
+	}
+
+	spec := &api.MessageSendSpec{MaxFee: defaultEstimateMaxFee}
+	if !opts.MaxFee.Nil() {
+		spec.MaxFee = opts.MaxFee
+	}
+	msg, err = gapi.GasEstimateMessageGas(ctx, msg, spec, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("GasEstimateMessageGas error: %w", err)
+	}
+
+	// use basefee + 20%
+	newGasFeeCap := big.Div(big.Mul(big.Int(basefee), big.NewInt(6)), big.NewInt(5))
+	if big.Cmp(msg.GasFeeCap, newGasFeeCap) < 0 {
+		msg.GasFeeCap = newGasFeeCap
+	}
+
+	if !opts.MaxFee.Nil() && msg.GasLimit > 0 {
+		maxFeeCap := big.Div(opts.MaxFee, big.NewInt(msg.GasLimit))
+		if big.Cmp(msg.GasFeeCap, maxFeeCap) > 0 {
+			msg.GasFeeCap = maxFeeCap
+		}
+		if big.Cmp(msg.GasFeeCap, big.Int(basefee)) < 0 {
+			return nil, fmt.Errorf("max fee %s does not cover the base fee %s for gas limit %d", chaintypes.FIL(opts.MaxFee), chaintypes.FIL(basefee), msg.GasLimit)
+		}
+	}
+	if big.Cmp(msg.GasPremium, msg.GasFeeCap) > 0 {
+		if !opts.GasPremium.Nil() {
+			return nil, fmt.Errorf("gas premium %s is above the gas fee cap %s allowed by the max fee", chaintypes.FIL(opts.GasPremium), chaintypes.FIL(msg.GasFeeCap))
+		}
+		msg.GasPremium = msg.GasFeeCap
+	}
+	return msg, nil
+}
+
+// estimateMessages estimates the gas of the messages without signing them,
+// for a dry-run
+func estimateMessages(ctx context.Context, gapi api.Gateway, msgs []*chaintypes.Message, opts MessageOptions) ([]*chaintypes.Message, error) {
+	var estimated []*chaintypes.Message
+	for _, msg := range msgs {
+		emsg, err := estimateMessage(ctx, gapi, msg, opts)
+		if err != nil {
+			return nil, err
+		}
+		estimated = append(estimated, emsg)
+	}
+	return estimated, nil
+}
+
+// pushMessages signs the messages with the client wallet and pushes them to
+// the mpool, recording them in the message store. Unless assumeYes is set, the
+// gas costs of each message have to be confirmed on the terminal; messages
+// that are not confirmed are not sent and their cid is cid.Undef in the
+// returned slice, which is aligned with msgs.
+func (client *Client) pushMessages(ctx context.Context, gapi api.Gateway, n *clinode.Node, msgs []*chaintypes.Message, assumeYes bool) ([]cid.Cid, error) {
+	var mcids []cid.Cid
+
+	ds := ds_sync.MutexWrap(datastore.NewMapDatastore())
+	signer := messagesigner.NewMessageSigner(signerWallet{client.nodeSigner(n)}, &modules.MpoolNonceAPI{ChainModule: gapi, StateModule: gapi}, ds)
+	for _, msg := range msgs {
+		smsg, err := signAndPush(ctx, gapi, signer, msg, assumeYes, client.msgOpts)
+		if err != nil {
+			return mcids, err
+		}
+		if smsg == nil {
+			fmt.Printf("message %s with method %s not sent\n", msg.Cid(), msg.Method.String())
+			mcids = append(mcids, cid.Undef)
+			continue
+		}
+		client.recordMessage(ctx, smsg.Cid(), func(store *MessageStore) error {
+			return store.Insert(ctx, smsg)
+		})
+		mcids = append(mcids, smsg.Cid())
+	}
+
+	var mcidStr []string
+	for _, c := range mcids {
+		if c.Defined() {
+			mcidStr = append(mcidStr, c.String())
+		}
+	}
+	logs.GetLogger().Info("submitted message[s]", mcidStr)
+	return mcids, nil
+}
+
+// recordMessage updates the message store. A message that cannot be recorded
+// has still been sent, so failures are only logged.
+func (client *Client) recordMessage(ctx context.Context, mcid cid.Cid, update func(store *MessageStore) error) {
+	store, err := client.MessageStore()
+	if err == nil {
+		defer store.Close()
+		err = update(store)
+	}
+	if err != nil {
+		logs.GetLogger().Warn("message ", mcid, ": updating message store failed: ", err)
+	}
+}
+
+// signAndPush signs the message and pushes it to the mpool. It returns nil if
+// the message was not confirmed.
+func signAndPush(ctx context.Context, gapi api.Gateway, signer *messagesigner.MessageSigner, msg *chaintypes.Message, assumeYes bool, opts MessageOptions) (*chaintypes.SignedMessage, error) {
+	msg, err := estimateMessage(ctx, gapi, msg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	smsg, err := signer.SignMessage(ctx, msg, nil, func(*chaintypes.SignedMessage) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("about to send message with the following gas costs")
+	maxFee := big.Mul(smsg.Message.GasFeeCap, big.NewInt(smsg.Message.GasLimit))
+	fmt.Println("max fee:     ", chaintypes.FIL(maxFee), "(absolute maximum amount you are willing to pay to get your transaction confirmed)")
+	fmt.Println("gas fee cap: ", chaintypes.FIL(smsg.Message.GasFeeCap))
+	fmt.Println("gas limit:   ", smsg.Message.GasLimit)
+	fmt.Println("gas premium: ", chaintypes.FIL(smsg.Message.GasPremium))
+	fmt.Println("nonce:       ", smsg.Message.Nonce)
+	fmt.Println()
+	if !assumeYes {
+		ok, err := confirm("Proceed? Yes [Y/y] / No [N/n], Ctrl+C (^C) to exit")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			fmt.Println("Message not sent")
+			return nil, nil
+		}
+	}
+
+	mcid, err := gapi.MpoolPush(ctx, smsg)
+	if err != nil {
+		return nil, fmt.Errorf("mpool push: failed to push message: %w", err)
+	}
+	fmt.Println("sent message: ", mcid)
+	return smsg, nil
+}
+
+func confirm(label string) (bool, error) {
+	validate := func(input string) error {
+		if strings.EqualFold(input, "y") || strings.EqualFold(input, "yes") {
+			return nil
+		}
+		if strings.EqualFold(input, "n") || strings.EqualFold(input, "no") {
+			return nil
+		}
+		return errors.New("incorrect input")
+	}
+
+	prompt := promptui.Prompt{
+		Label: label,
+		Templates: &promptui.PromptTemplates{
+			Prompt:  "{{ . }} ",
+			Valid:   "{{ . | green }} ",
+			Invalid: "{{ . | red }} ",
+			Success: "{{ . | cyan | bold }} ",
+		},
+		Validate: validate,
+	}
+
+	input, err := prompt.Run()
+	if err != nil {
+		return false, err
+	}
+	return !strings.Contains(strings.ToLower(input), "n"), nil
+}
+
+// waitMessages waits for the messages to be included in a block and checks
+// that they were executed successfully. Undefined cids are skipped.
+func (client *Client) waitMessages(ctx context.Context, gapi api.Gateway, mcids []cid.Cid) error {
+	logs.GetLogger().Info("waiting for message to be included in a block")
+
+	eg := errgroup.Group{}
+	eg.SetLimit(10)
+	for _, msg := range mcids {
+		if !msg.Defined() {
+			continue
+		}
+		m := msg
+		eg.Go(func() error {
+			_, err := client.waitMessage(ctx, gapi, m)
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// waitMessage waits for the message to be executed on chain and records its
+// receipt in the message store
+func (client *Client) waitMessage(ctx context.Context, gapi api.Gateway, mcid cid.Cid) (*api.MsgLookup, error) {
+	opts := client.msgOpts
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	wait, err := gapi.StateWaitMsg(ctx, mcid, opts.confidence(), opts.lookback(), true)
+	if err != nil {
+		client.recordMessage(context.Background(), mcid, func(store *MessageStore) error {
+			return store.SetError(context.Background(), mcid, err.Error())
+		})
+		return nil, fmt.Errorf("timeout waiting for message to land on chain %s: %w", mcid, err)
+	}
+
+	client.recordMessage(ctx, mcid, func(store *MessageStore) error {
+		if wait.Message != mcid {
+			// the replacement of the message was executed
+			if err := store.SetReceipt(ctx, wait.Message, wait); err != nil {
+				return err
+			}
+		}
+		return store.SetReceipt(ctx, mcid, wait)
+	})
+
+	if wait.Receipt.ExitCode.IsError() {
+		return wait, fmt.Errorf("failed to execute message %s: %w", wait.Message, wait.Receipt.ExitCode)
+	}
+	return wait, nil
+}
+
+// ResumeMessages waits for the messages of the message store that are still
+// pending, e.g. after a restart, and returns their final records
+func (client *Client) ResumeMessages(ctx context.Context) ([]*MessageRecord, error) {
+	store, err := client.MessageStore()
+	if err != nil {
+		return nil, fmt.Errorf("opening message store: %w", err)
+	}
+	defer store.Close()
+
+	pending, err := store.ListByState(ctx, MessageStatePending)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var mcids []cid.Cid
+	for _, msg := range pending {
+		mcids = append(mcids, msg.Cid)
+	}
+	if err := client.waitMessages(ctx, gapi, mcids); err != nil {
+		logs.GetLogger().Warn("waiting for pending messages: ", err)
+	}
+
+	var records []*MessageRecord
+	for _, mcid := range mcids {
+		msg, err := store.ByCid(ctx, mcid)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, msg)
+	}
+	return records, nil
+}
+
+// StuckMessages returns the pending messages of the message store that were
+// pushed more than olderThan ago and are not on chain yet
+func (client *Client) StuckMessages(ctx context.Context, olderThan time.Duration) ([]*MessageRecord, error) {
+	store, err := client.MessageStore()
+	if err != nil {
+		return nil, fmt.Errorf("opening message store: %w", err)
+	}
+	defer store.Close()
+
+	pending, err := store.ListByState(ctx, MessageStatePending)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var stuck []*MessageRecord
+	for _, msg := range pending {
+		if time.Since(msg.CreatedAt) < olderThan {
+			continue
+		}
+		lookup, err := gapi.StateSearchMsg(ctx, chaintypes.EmptyTSK, msg.Cid, client.msgOpts.lookback(), true)
+		if err != nil {
+			return nil, fmt.Errorf("searching message %s: %w", msg.Cid, err)
+		}
+		if lookup != nil {
+			// the message landed while nobody was waiting for it
+			if err := store.SetReceipt(ctx, msg.Cid, lookup); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		stuck = append(stuck, msg)
+	}
+	return stuck, nil
+}
+
+// ReplaceMessage replaces a pending message with a copy that has the same
+// nonce and a gas premium raised by the mpool replace-by-fee ratio, within
+// the MaxFee of the message options. It returns the cid of the replacement.
+func (client *Client) ReplaceMessage(ctx context.Context, mcid cid.Cid) (cid.Cid, error) {
+	store, err := client.MessageStore()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("opening message store: %w", err)
+	}
+	defer store.Close()
+
+	old, err := store.ByCid(ctx, mcid)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if old.State != MessageStatePending {
+		return cid.Undef, fmt.Errorf("message %s is %s, only pending messages can be replaced", mcid, old.State)
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	basefee := head.Blocks()[0].ParentBaseFee
+
+	msg := old.SignedMessage.Message
+	msg.GasPremium = messagepool.ComputeRBF(msg.GasPremium, messagepool.ReplaceByFeePercentageDefault)
+	minFeeCap := big.Div(big.Mul(big.Int(basefee), big.NewInt(6)), big.NewInt(5))
+	msg.GasFeeCap = big.Max(big.Max(msg.GasFeeCap, minFeeCap), msg.GasPremium)
+
+	if maxFee := client.msgOpts.MaxFee; !maxFee.Nil() {
+		if fee := big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit)); fee.GreaterThan(maxFee) {
+			return cid.Undef, fmt.Errorf("replacing message %s needs a max fee of %s, above the limit of %s", mcid, chaintypes.FIL(fee), chaintypes.FIL(maxFee))
+		}
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer n.Host.Close()
+
+	mb, err := msg.ToStorageBlock()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("serializing message: %w", err)
+	}
+	// delegated senders sign the Ethereum transaction of the message
+	sb, err := messagesigner.SigningBytes(&msg, msg.From.Protocol())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("getting signing bytes of message: %w", err)
+	}
+	sig, err := client.nodeSigner(n).WalletSign(ctx, msg.From, sb, api.MsgMeta{Type: api.MTChainMsg, Extra: mb.RawData()})
+	if err != nil {
+		return cid.Undef, fmt.Errorf("signing replacement of message %s: %w", mcid, err)
+	}
+	smsg := &chaintypes.SignedMessage{Message: msg, Signature: *sig}
+
+	newCid, err := gapi.MpoolPush(ctx, smsg)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("mpool push: failed to push replacement of message %s: %w", mcid, err)
+	}
+	logs.GetLogger().Info("replaced message ", mcid, " with ", newCid, ", gas premium ", chaintypes.FIL(msg.GasPremium), ", gas fee cap ", chaintypes.FIL(msg.GasFeeCap))
+
+	if err := store.Insert(ctx, smsg); err != nil {
+		return newCid, err
+	}
+	return newCid, store.SetReplaced(ctx, mcid, newCid)
+}
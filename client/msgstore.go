@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+)
+
+const MessageStoreDBName = "messages.db"
+
+// States of a message pushed by the client
+const (
+	MessageStatePending  = "Pending"  // pushed to the mpool, not seen on chain yet
+	MessageStateExecuted = "Executed" // executed on chain successfully
+	MessageStateFailed   = "Failed"   // executed on chain with an error exit code
+	MessageStateReplaced = "Replaced" // replaced by a message with the same nonce and higher gas
+)
+
+var createMessageStoreDBSQL = `
+CREATE TABLE IF NOT EXISTS ClientMessages (
+          Cid           TEXT PRIMARY KEY,
+          CreatedAt     DateTime,
+          UpdatedAt     DateTime,
+          FromAddr      TEXT,
+          ToAddr        TEXT,
+          Nonce         INT,
+          Method        INT,
+          SignedMessage BLOB,
+          State         TEXT,
+          ReplacedBy    TEXT,
+          ExecutedCid   TEXT,
+          ExitCode      INT,
+          GasUsed       INT,
+          ReturnValue   BLOB,
+          Height        INT,
+          Error         TEXT
+);
+
+CREATE INDEX IF NOT EXISTS index_client_messages_state on ClientMessages(State);
+CREATE INDEX IF NOT EXISTS index_client_messages_from_nonce on ClientMessages(FromAddr, Nonce);
+`
+
+// MessageRecord is a message pushed by the client, with its receipt once it
+// has been executed on chain
+type MessageRecord struct {
+	Cid           cid.Cid
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	From          string
+	To            string
+	Nonce         uint64
+	Method        uint64
+	SignedMessage *chaintypes.SignedMessage
+	State         string
+	ReplacedBy    cid.Cid // the message that replaced this one, if it was replaced
+	ExecutedCid   cid.Cid // the message that was executed, which differs from Cid if only the gas values were changed
+	ExitCode      exitcode.ExitCode
+	GasUsed       int64
+	Return        []byte
+	Height        int64
+	Error         string
+}
+
+// MessageStore records the messages pushed by the client in the client repo,
+// so that they can be waited for again after a restart
+type MessageStore struct {
+	db *sql.DB
+}
+
+func NewMessageStore(repo string) (*MessageStore, error) {
+	repoPath, err := homedir.Expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := path.Join(repoPath, MessageStoreDBName)
+	d, err := db.SqlDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.ExecContext(context.TODO(), createMessageStoreDBSQL); err != nil {
+		d.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to create tables in message store DB: %w", err)
+	}
+	return &MessageStore{db: d}, nil
+}
+
+// MessageStore opens the message store in the client repo
+func (client *Client) MessageStore() (*MessageStore, error) {
+	return NewMessageStore(client.ClientRepo)
+}
+
+func (m *MessageStore) Close() error {
+	return m.db.Close()
+}
+
+// Insert records a message that was pushed to the mpool
+func (m *MessageStore) Insert(ctx context.Context, smsg *chaintypes.SignedMessage) error {
+	var buf bytes.Buffer
+	if err := smsg.MarshalCBOR(&buf); err != nil {
+		return fmt.Errorf("serializing message %s: %w", smsg.Cid(), err)
+	}
+
+	now := time.Now()
+	qry := "INSERT OR REPLACE INTO ClientMessages (Cid, CreatedAt, UpdatedAt, FromAddr, ToAddr, Nonce, Method, SignedMessage, State, ReplacedBy, ExecutedCid, ExitCode, GasUsed, ReturnValue, Height, Error) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, '', '', 0, 0, NULL, 0, '')"
+	values := []interface{}{smsg.Cid().String(), now, now, smsg.Message.From.String(), smsg.Message.To.String(), smsg.Message.Nonce, uint64(smsg.Message.Method),
+		buf.Bytes(), MessageStatePending}
+	if _, err := m.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("inserting message %s: %w", smsg.Cid(), err)
+	}
+	return nil
+}
+
+// SetReceipt records the outcome of a message executed on chain
+func (m *MessageStore) SetReceipt(ctx context.Context, mcid cid.Cid, lookup *api.MsgLookup) error {
+	state := MessageStateExecuted
+	if lookup.Receipt.ExitCode.IsError() {
+		state = MessageStateFailed
+	}
+	qry := "UPDATE ClientMessages SET State=?, ExecutedCid=?, ExitCode=?, GasUsed=?, ReturnValue=?, Height=?, UpdatedAt=? WHERE Cid=?"
+	values := []interface{}{state, lookup.Message.String(), int64(lookup.Receipt.ExitCode), lookup.Receipt.GasUsed, lookup.Receipt.Return, int64(lookup.Height),
+		time.Now(), mcid.String()}
+	if _, err := m.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("recording receipt of message %s: %w", mcid, err)
+	}
+	return nil
+}
+
+// SetReplaced records that a message was replaced by another one with the
+// same nonce
+func (m *MessageStore) SetReplaced(ctx context.Context, mcid, replacedBy cid.Cid) error {
+	qry := "UPDATE ClientMessages SET State=?, ReplacedBy=?, UpdatedAt=? WHERE Cid=?"
+	if _, err := m.db.ExecContext(ctx, qry, MessageStateReplaced, replacedBy.String(), time.Now(), mcid.String()); err != nil {
+		return fmt.Errorf("recording replacement of message %s: %w", mcid, err)
+	}
+	return nil
+}
+
+// SetError records an error waiting for a message, without changing its state
+func (m *MessageStore) SetError(ctx context.Context, mcid cid.Cid, msgErr string) error {
+	qry := "UPDATE ClientMessages SET Error=?, UpdatedAt=? WHERE Cid=?"
+	if _, err := m.db.ExecContext(ctx, qry, msgErr, time.Now(), mcid.String()); err != nil {
+		return fmt.Errorf("recording error of message %s: %w", mcid, err)
+	}
+	return nil
+}
+
+const messageFields = "Cid, CreatedAt, UpdatedAt, FromAddr, ToAddr, Nonce, Method, SignedMessage, State, ReplacedBy, ExecutedCid, ExitCode, GasUsed, ReturnValue, Height, Error"
+
+// ByCid returns the message with the given cid
+func (m *MessageStore) ByCid(ctx context.Context, mcid cid.Cid) (*MessageRecord, error) {
+	qry := "SELECT " + messageFields + " FROM ClientMessages WHERE Cid=?"
+	row := m.db.QueryRowContext(ctx, qry, mcid.String())
+	msg, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("message %s: %w", mcid, db.ErrNotFound)
+	}
+	return msg, err
+}
+
+// ListByState returns all messages currently in the given state
+func (m *MessageStore) ListByState(ctx context.Context, state string) ([]*MessageRecord, error) {
+	return m.list(ctx, "WHERE State=?", state)
+}
+
+// List returns all messages
+func (m *MessageStore) List(ctx context.Context) ([]*MessageRecord, error) {
+	return m.list(ctx, "")
+}
+
+func (m *MessageStore) list(ctx context.Context, where string, args ...interface{}) ([]*MessageRecord, error) {
+	qry := "SELECT " + messageFields + " FROM ClientMessages " + where + " ORDER BY CreatedAt"
+	rows, err := m.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*MessageRecord
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func scanMessage(row db.Scannable) (*MessageRecord, error) {
+	var msg MessageRecord
+	var mcid, replacedBy, executedCid string
+	var signed, ret []byte
+	var exitCode int64
+	err := row.Scan(&mcid, &msg.CreatedAt, &msg.UpdatedAt, &msg.From, &msg.To, &msg.Nonce, &msg.Method, &signed, &msg.State, &replacedBy, &executedCid,
+		&exitCode, &msg.GasUsed, &ret, &msg.Height, &msg.Error)
+	if err != nil {
+		return nil, err
+	}
+	msg.ExitCode = exitcode.ExitCode(exitCode)
+	msg.Return = ret
+
+	if msg.Cid, err = cid.Parse(mcid); err != nil {
+		return nil, fmt.Errorf("parsing message cid %s: %w", mcid, err)
+	}
+	if replacedBy != "" {
+		if msg.ReplacedBy, err = cid.Parse(replacedBy); err != nil {
+			return nil, fmt.Errorf("parsing cid of the replacement of message %s: %w", mcid, err)
+		}
+	}
+	if executedCid != "" {
+		if msg.ExecutedCid, err = cid.Parse(executedCid); err != nil {
+			return nil, fmt.Errorf("parsing executed cid of message %s: %w", mcid, err)
+		}
+	}
+
+	msg.SignedMessage, err = chaintypes.DecodeSignedMessage(signed)
+	if err != nil {
+		return nil, fmt.Errorf("decoding message %s: %w", mcid, err)
+	}
+	return &msg, nil
+}
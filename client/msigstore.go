@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/boost/db"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/ipfs/go-cid"
+	"github.com/mitchellh/go-homedir"
+)
+
+const MultisigStoreDBName = "multisig.db"
+
+// States of a multisig transaction proposed by the client
+const (
+	MultisigTxStateProposed = "Proposed" // pending on chain, waiting for approvals
+	MultisigTxStateExecuted = "Executed" // approved and executed successfully
+	MultisigTxStateFailed   = "Failed"   // approved and executed with an error exit code
+	MultisigTxStateClosed   = "Closed"   // no longer pending, executed or cancelled outside of the client
+)
+
+var createMultisigStoreDBSQL = `
+CREATE TABLE IF NOT EXISTS MultisigTransactions (
+          Msig        TEXT,
+          TxnID       INT,
+          CreatedAt   DateTime,
+          UpdatedAt   DateTime,
+          Proposer    TEXT,
+          ProposeCid  TEXT,
+          ToAddr      TEXT,
+          Value       TEXT,
+          Method      INT,
+          Params      BLOB,
+          Approvals   TEXT,
+          State       TEXT,
+          ExecutedCid TEXT,
+          ExitCode    INT,
+          Allocations TEXT,
+          Error       TEXT,
+          PRIMARY KEY (Msig, TxnID)
+);
+
+CREATE INDEX IF NOT EXISTS index_multisig_transactions_state on MultisigTransactions(State);
+`
+
+// MultisigTransaction is a transaction proposed by the client to a multisig
+// wallet, e.g. a DataCap allocation, with its approvals and execution
+type MultisigTransaction struct {
+	Msig        string // id address of the multisig
+	TxnID       int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Proposer    string // id address of the signer that proposed the transaction
+	ProposeCid  cid.Cid
+	To          string
+	Value       abi.TokenAmount
+	Method      abi.MethodNum
+	Params      []byte
+	Approvals   []string // signers that approved the transaction, the proposer included
+	State       string
+	ExecutedCid cid.Cid // the message that executed the transaction, if the client sent it
+	ExitCode    exitcode.ExitCode
+	Allocations map[string]uint64 // allocation id by piece cid, for executed DataCap allocations
+	Error       string
+}
+
+// MultisigStore records the multisig transactions proposed by the client in
+// the client repo
+type MultisigStore struct {
+	db *sql.DB
+}
+
+func NewMultisigStore(repo string) (*MultisigStore, error) {
+	repoPath, err := homedir.Expand(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := path.Join(repoPath, MultisigStoreDBName)
+	d, err := db.SqlDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.ExecContext(context.TODO(), createMultisigStoreDBSQL); err != nil {
+		d.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to create tables in multisig store DB: %w", err)
+	}
+	return &MultisigStore{db: d}, nil
+}
+
+// MultisigStore opens the multisig store in the client repo
+func (client *Client) MultisigStore() (*MultisigStore, error) {
+	return NewMultisigStore(client.ClientRepo)
+}
+
+func (m *MultisigStore) Close() error {
+	return m.db.Close()
+}
+
+// Insert records a transaction proposed to a multisig
+func (m *MultisigStore) Insert(ctx context.Context, tx *MultisigTransaction) error {
+	now := time.Now()
+	tx.CreatedAt, tx.UpdatedAt = now, now
+	allocations, err := json.Marshal(tx.Allocations)
+	if err != nil {
+		return err
+	}
+
+	qry := "INSERT OR REPLACE INTO MultisigTransactions (Msig, TxnID, CreatedAt, UpdatedAt, Proposer, ProposeCid, ToAddr, Value, Method, Params, Approvals, State, ExecutedCid, ExitCode, Allocations, Error) "
+	qry += "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	values := []interface{}{tx.Msig, tx.TxnID, now, now, tx.Proposer, tx.ProposeCid.String(), tx.To, tx.Value.String(), uint64(tx.Method), tx.Params,
+		strings.Join(tx.Approvals, ","), tx.State, cidString(tx.ExecutedCid), int64(tx.ExitCode), string(allocations), tx.Error}
+	if _, err := m.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("inserting transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+	}
+	return nil
+}
+
+// Update records the approvals and execution of a transaction
+func (m *MultisigStore) Update(ctx context.Context, tx *MultisigTransaction) error {
+	tx.UpdatedAt = time.Now()
+	allocations, err := json.Marshal(tx.Allocations)
+	if err != nil {
+		return err
+	}
+
+	qry := "UPDATE MultisigTransactions SET Approvals=?, State=?, ExecutedCid=?, ExitCode=?, Allocations=?, Error=?, UpdatedAt=? WHERE Msig=? AND TxnID=?"
+	values := []interface{}{strings.Join(tx.Approvals, ","), tx.State, cidString(tx.ExecutedCid), int64(tx.ExitCode), string(allocations), tx.Error,
+		tx.UpdatedAt, tx.Msig, tx.TxnID}
+	if _, err := m.db.ExecContext(ctx, qry, values...); err != nil {
+		return fmt.Errorf("updating transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+	}
+	return nil
+}
+
+const multisigTxFields = "Msig, TxnID, CreatedAt, UpdatedAt, Proposer, ProposeCid, ToAddr, Value, Method, Params, Approvals, State, ExecutedCid, ExitCode, Allocations, Error"
+
+// ByID returns the transaction of the multisig with the given id
+func (m *MultisigStore) ByID(ctx context.Context, msig string, txnID int64) (*MultisigTransaction, error) {
+	qry := "SELECT " + multisigTxFields + " FROM MultisigTransactions WHERE Msig=? AND TxnID=?"
+	row := m.db.QueryRowContext(ctx, qry, msig, txnID)
+	tx, err := scanMultisigTx(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("transaction %d of multisig %s: %w", txnID, msig, db.ErrNotFound)
+	}
+	return tx, err
+}
+
+// ListByMsig returns the transactions proposed to the multisig
+func (m *MultisigStore) ListByMsig(ctx context.Context, msig string) ([]*MultisigTransaction, error) {
+	return m.list(ctx, "WHERE Msig=?", msig)
+}
+
+// ListByState returns all transactions currently in the given state
+func (m *MultisigStore) ListByState(ctx context.Context, state string) ([]*MultisigTransaction, error) {
+	return m.list(ctx, "WHERE State=?", state)
+}
+
+func (m *MultisigStore) list(ctx context.Context, where string, args ...interface{}) ([]*MultisigTransaction, error) {
+	qry := "SELECT " + multisigTxFields + " FROM MultisigTransactions " + where + " ORDER BY CreatedAt"
+	rows, err := m.db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txs []*MultisigTransaction
+	for rows.Next() {
+		tx, err := scanMultisigTx(rows)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, rows.Err()
+}
+
+func scanMultisigTx(row db.Scannable) (*MultisigTransaction, error) {
+	var tx MultisigTransaction
+	var proposeCid, value, approvals, executedCid, allocations string
+	var method uint64
+	var exitCode int64
+	err := row.Scan(&tx.Msig, &tx.TxnID, &tx.CreatedAt, &tx.UpdatedAt, &tx.Proposer, &proposeCid, &tx.To, &value, &method, &tx.Params, &approvals,
+		&tx.State, &executedCid, &exitCode, &allocations, &tx.Error)
+	if err != nil {
+		return nil, err
+	}
+	tx.Method = abi.MethodNum(method)
+	tx.ExitCode = exitcode.ExitCode(exitCode)
+	if approvals != "" {
+		tx.Approvals = strings.Split(approvals, ",")
+	}
+
+	if tx.Value, err = big.FromString(value); err != nil {
+		return nil, fmt.Errorf("parsing value of transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+	}
+	if tx.ProposeCid, err = cid.Parse(proposeCid); err != nil {
+		return nil, fmt.Errorf("parsing propose cid of transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+	}
+	if executedCid != "" {
+		if tx.ExecutedCid, err = cid.Parse(executedCid); err != nil {
+			return nil, fmt.Errorf("parsing executed cid of transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+		}
+	}
+	if allocations != "" && allocations != "null" {
+		if err := json.Unmarshal([]byte(allocations), &tx.Allocations); err != nil {
+			return nil, fmt.Errorf("parsing allocations of transaction %d of multisig %s: %w", tx.TxnID, tx.Msig, err)
+		}
+	}
+	return &tx, nil
+}
+
+func cidString(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	return c.String()
+}
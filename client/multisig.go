@@ -0,0 +1,488 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin/v9/datacap"
+	"github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// ErrMultisigDealClient is returned when a multisig wallet is used as the
+// client of a deal proposal, which only a key wallet can sign
+var ErrMultisigDealClient = errors.New("a multisig wallet cannot sign deal proposals, use a key wallet as the deal client and allocate DataCap from the multisig")
+
+type actorAPI interface {
+	StateGetActor(ctx context.Context, addr address.Address, tsk chaintypes.TipSetKey) (*chaintypes.Actor, error)
+}
+
+// isMultisig reports whether the address is a multisig actor. Only id and
+// actor addresses can be multisigs, so other addresses are not looked up.
+func isMultisig(ctx context.Context, sapi actorAPI, addr address.Address) (bool, error) {
+	switch addr.Protocol() {
+	case address.ID, address.Actor:
+	default:
+		return false, nil
+	}
+	act, err := sapi.StateGetActor(ctx, addr, chaintypes.EmptyTSK)
+	if err != nil {
+		return false, fmt.Errorf("getting actor %s: %w", addr, err)
+	}
+	return lbuiltin.IsMultisigActor(act.Code), nil
+}
+
+// MultisigAllocationResult is the outcome of proposing DataCap allocations to
+// a multisig
+type MultisigAllocationResult struct {
+	Transactions []*MultisigTransaction // proposed transactions, one per allocation message
+	Failures     []AllocationFailure
+}
+
+// MultisigAllocateDeals proposes to the multisig the DataCap transfers that
+// allocate its DataCap for the pieces. The proposer has to be a signer of the
+// multisig with its key in the client wallet. The transactions are recorded
+// in the multisig store; they are executed once approved by enough signers
+// with MultisigApprove, or right away if the multisig needs a single approval.
+func (client *Client) MultisigAllocateDeals(msigAddress, proposerAddress string, reqs []AllocationRequest, batchSize int, assumeYes bool) (*MultisigAllocationResult, error) {
+	ctx := context.Background()
+	msigAddr, err := ParseAddress(msigAddress)
+	if err != nil {
+		return nil, err
+	}
+	proposerAddr, err := ParseAddress(proposerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	msigID, proposerID, err := multisigSigner(ctx, gapi, msigAddr, proposerAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &MultisigAllocationResult{}
+	prep, err := prepareAllocations(ctx, gapi, msigID, reqs, batchSize)
+	res.Failures = prep.failures
+	if err != nil {
+		return res, err
+	}
+
+	builder, err := multisigBuilder(ctx, gapi, proposerAddr)
+	if err != nil {
+		return res, err
+	}
+	var proposals []*chaintypes.Message
+	for _, inner := range prep.msgs {
+		msg, err := builder.Propose(msigID, inner.To, inner.Value, inner.Method, inner.Params)
+		if err != nil {
+			return res, fmt.Errorf("building proposal to multisig %s: %w", msigAddr, err)
+		}
+		proposals = append(proposals, msg)
+	}
+
+	oldallocations, err := gapi.StateGetAllocations(ctx, msigID, chaintypes.EmptyTSK)
+	if err != nil {
+		return res, fmt.Errorf("failed to get allocations: %w", err)
+	}
+
+	// on a push failure the proposals pushed before it are still waited for
+	// and recorded, so that they can be approved
+	mcids, pushErr := client.pushMessages(ctx, gapi, n, proposals, assumeYes)
+
+	// the proposals carry the batches of requests in order, as in allocateDeals
+	fail := func(i int, mcid cid.Cid, reason string) {
+		end := (i + 1) * prep.batchSize
+		if end > len(prep.valid) {
+			end = len(prep.valid)
+		}
+		for j := i * prep.batchSize; j < end; j++ {
+			req := reqs[prep.valid[j]]
+			res.Failures = append(res.Failures, AllocationFailure{PieceCid: req.PieceCid, Miner: req.Miner, Message: mcid, Error: reason})
+		}
+	}
+
+	var applied []*MultisigTransaction
+	for i, mcid := range mcids {
+		if !mcid.Defined() {
+			fail(i, mcid, "multisig proposal was not sent")
+			continue
+		}
+		lookup, err := client.waitMessage(ctx, gapi, mcid)
+		if err != nil {
+			fail(i, mcid, err.Error())
+			continue
+		}
+		var ret multisig.ProposeReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			fail(i, mcid, fmt.Sprintf("decoding multisig proposal return: %s", err))
+			continue
+		}
+
+		inner := prep.msgs[i]
+		tx := &MultisigTransaction{
+			Msig:       msigID.String(),
+			TxnID:      int64(ret.TxnID),
+			Proposer:   proposerID.String(),
+			ProposeCid: mcid,
+			To:         inner.To.String(),
+			Value:      inner.Value,
+			Method:     inner.Method,
+			Params:     inner.Params,
+			Approvals:  []string{proposerID.String()},
+			State:      MultisigTxStateProposed,
+		}
+		if ret.Applied {
+			setMultisigTxExecuted(tx, lookup.Message, ret.Code)
+			if tx.State == MultisigTxStateFailed {
+				fail(i, mcid, tx.Error)
+			} else {
+				applied = append(applied, tx)
+			}
+		}
+		logs.GetLogger().Infof("proposed transaction %d to multisig %s in message %s, state %s", tx.TxnID, msigAddr, mcid, tx.State)
+		res.Transactions = append(res.Transactions, tx)
+	}
+	for i := len(mcids); i < len(proposals); i++ {
+		fail(i, cid.Undef, fmt.Sprintf("multisig proposal was not sent: %s", pushErr))
+	}
+
+	if len(applied) > 0 {
+		if err := multisigTxAllocations(ctx, gapi, msigID, oldallocations, applied); err != nil {
+			logs.GetLogger().Warn("matching allocations of multisig ", msigAddr, ": ", err)
+		}
+	}
+
+	client.recordMultisigTxs(ctx, func(store *MultisigStore) error {
+		for _, tx := range res.Transactions {
+			if err := store.Insert(ctx, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return res, pushErr
+}
+
+// MultisigApprove approves a transaction proposed by the client to the
+// multisig. The transaction is only approved if it is still the one that was
+// proposed. If the approval completes the threshold of the multisig, the
+// transaction is executed and its DataCap allocations are recorded.
+func (client *Client) MultisigApprove(msigAddress, approverAddress string, txnID int64, assumeYes bool) (*MultisigTransaction, error) {
+	ctx := context.Background()
+	msigAddr, err := ParseAddress(msigAddress)
+	if err != nil {
+		return nil, err
+	}
+	approverAddr, err := ParseAddress(approverAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	msigID, approverID, err := multisigSigner(ctx, gapi, msigAddr, approverAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := client.MultisigStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	tx, err := store.ByID(ctx, msigID.String(), txnID)
+	if err != nil {
+		return nil, err
+	}
+	if tx.State != MultisigTxStateProposed {
+		return nil, fmt.Errorf("transaction %d of multisig %s is %s, not pending", txnID, msigAddr, tx.State)
+	}
+	for _, a := range tx.Approvals {
+		if a == approverID.String() {
+			return nil, fmt.Errorf("transaction %d of multisig %s is already approved by %s", txnID, msigAddr, approverAddr)
+		}
+	}
+
+	// the hash makes the approval fail if the pending transaction differs
+	// from the one the client proposed
+	requester, err := address.NewFromString(tx.Proposer)
+	if err != nil {
+		return nil, err
+	}
+	to, err := address.NewFromString(tx.To)
+	if err != nil {
+		return nil, err
+	}
+	hash := &multisig.ProposalHashData{
+		Requester: requester,
+		To:        to,
+		Value:     tx.Value,
+		Method:    tx.Method,
+		Params:    tx.Params,
+	}
+
+	builder, err := multisigBuilder(ctx, gapi, approverAddr)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := builder.Approve(msigID, uint64(txnID), hash)
+	if err != nil {
+		return nil, fmt.Errorf("building approval of transaction %d of multisig %s: %w", txnID, msigAddr, err)
+	}
+
+	oldallocations, err := gapi.StateGetAllocations(ctx, msigID, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get allocations: %w", err)
+	}
+
+	n, err := client.setupNode()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Host.Close()
+
+	mcids, err := client.pushMessages(ctx, gapi, n, []*chaintypes.Message{msg}, assumeYes)
+	if err != nil {
+		return nil, err
+	}
+	if !mcids[0].Defined() {
+		return nil, fmt.Errorf("approval of transaction %d of multisig %s was not sent", txnID, msigAddr)
+	}
+	lookup, err := client.waitMessage(ctx, gapi, mcids[0])
+	if err != nil {
+		return nil, err
+	}
+	var ret multisig.ApproveReturn
+	if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+		return nil, fmt.Errorf("decoding multisig approval return: %w", err)
+	}
+
+	tx.Approvals = append(tx.Approvals, approverID.String())
+	if ret.Applied {
+		setMultisigTxExecuted(tx, lookup.Message, ret.Code)
+		if tx.State == MultisigTxStateExecuted {
+			if err := multisigTxAllocations(ctx, gapi, msigID, oldallocations, []*MultisigTransaction{tx}); err != nil {
+				logs.GetLogger().Warn("matching allocations of multisig ", msigAddr, ": ", err)
+			}
+		}
+	}
+	logs.GetLogger().Infof("approved transaction %d of multisig %s in message %s, state %s", txnID, msigAddr, mcids[0], tx.State)
+
+	if err := store.Update(ctx, tx); err != nil {
+		return tx, err
+	}
+	return tx, nil
+}
+
+// MultisigTransactions returns the transactions proposed by the client to the
+// multisig, with their approvals refreshed from the chain. Transactions that
+// are no longer pending and were not executed by the client are closed.
+func (client *Client) MultisigTransactions(msigAddress string) ([]*MultisigTransaction, error) {
+	ctx := context.Background()
+	msigAddr, err := ParseAddress(msigAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	msigID, err := gapi.StateLookupID(ctx, msigAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("looking up id of multisig %s: %w", msigAddr, err)
+	}
+	pending, err := gapi.MsigGetPending(ctx, msigID, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, fmt.Errorf("getting pending transactions of multisig %s: %w", msigAddr, err)
+	}
+	pendingByID := make(map[int64]*api.MsigTransaction, len(pending))
+	for _, p := range pending {
+		pendingByID[p.ID] = p
+	}
+
+	store, err := client.MultisigStore()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	txs, err := store.ListByMsig(ctx, msigID.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		if tx.State != MultisigTxStateProposed {
+			continue
+		}
+		p, ok := pendingByID[tx.TxnID]
+		if !ok {
+			tx.State = MultisigTxStateClosed
+		} else {
+			tx.Approvals = nil
+			for _, a := range p.Approved {
+				tx.Approvals = append(tx.Approvals, a.String())
+			}
+		}
+		if err := store.Update(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+	return txs, nil
+}
+
+// multisigSigner checks that the address is a multisig and the signer is one
+// of its signers, and returns their id addresses
+func multisigSigner(ctx context.Context, gapi api.Gateway, msigAddr, signerAddr address.Address) (address.Address, address.Address, error) {
+	ok, err := isMultisig(ctx, gapi, msigAddr)
+	if err != nil {
+		return address.Undef, address.Undef, err
+	}
+	if !ok {
+		return address.Undef, address.Undef, fmt.Errorf("%s is not a multisig", msigAddr)
+	}
+	msigID, err := gapi.StateLookupID(ctx, msigAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return address.Undef, address.Undef, fmt.Errorf("looking up id of multisig %s: %w", msigAddr, err)
+	}
+	signerID, err := gapi.StateLookupID(ctx, signerAddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return address.Undef, address.Undef, fmt.Errorf("looking up id of signer %s: %w", signerAddr, err)
+	}
+
+	act, err := gapi.StateGetActor(ctx, msigID, chaintypes.EmptyTSK)
+	if err != nil {
+		return address.Undef, address.Undef, fmt.Errorf("getting actor %s: %w", msigAddr, err)
+	}
+	state, err := multisig.Load(adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(gapi))), act)
+	if err != nil {
+		return address.Undef, address.Undef, fmt.Errorf("loading state of multisig %s: %w", msigAddr, err)
+	}
+	signers, err := state.Signers()
+	if err != nil {
+		return address.Undef, address.Undef, err
+	}
+	for _, s := range signers {
+		if s == signerID {
+			return msigID, signerID, nil
+		}
+	}
+	return address.Undef, address.Undef, fmt.Errorf("%s is not a signer of multisig %s", signerAddr, msigAddr)
+}
+
+// multisigBuilder returns the builder of multisig messages sent by the signer
+// for the actors version of the current network
+func multisigBuilder(ctx context.Context, gapi api.Gateway, from address.Address) (multisig.MessageBuilder, error) {
+	nv, err := gapi.StateNetworkVersion(ctx, chaintypes.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	av, err := actorstypes.VersionForNetwork(nv)
+	if err != nil {
+		return nil, err
+	}
+	return multisig.Message(av, from), nil
+}
+
+func setMultisigTxExecuted(tx *MultisigTransaction, executed cid.Cid, code exitcode.ExitCode) {
+	tx.ExecutedCid = executed
+	tx.ExitCode = code
+	if code.IsError() {
+		tx.State = MultisigTxStateFailed
+		tx.Error = fmt.Sprintf("transaction %d of multisig %s failed: %s", tx.TxnID, tx.Msig, code)
+		return
+	}
+	tx.State = MultisigTxStateExecuted
+}
+
+// multisigTxAllocations matches the allocations made by the executed DataCap
+// transfers to their pieces, among the allocations that are not in old
+func multisigTxAllocations(ctx context.Context, gapi api.Gateway, msigID address.Address, old map[verifreg.AllocationId]verifreg.Allocation, txs []*MultisigTransaction) error {
+	newallocations, err := gapi.StateGetAllocations(ctx, msigID, chaintypes.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("failed to get allocations: %w", err)
+	}
+	for aid := range old {
+		delete(newallocations, aid)
+	}
+
+	for _, tx := range txs {
+		var transfer datacap.TransferParams
+		if err := transfer.UnmarshalCBOR(bytes.NewReader(tx.Params)); err != nil {
+			return fmt.Errorf("decoding DataCap transfer of transaction %d: %w", tx.TxnID, err)
+		}
+		var reqs verifreg.AllocationRequests
+		if err := reqs.UnmarshalCBOR(bytes.NewReader(transfer.OperatorData)); err != nil {
+			return fmt.Errorf("decoding allocation requests of transaction %d: %w", tx.TxnID, err)
+		}
+
+		tx.Allocations = make(map[string]uint64)
+		for _, req := range reqs.Allocations {
+			aid, ok := takeAllocation(newallocations, req.Data, req.Provider)
+			if !ok {
+				logs.GetLogger().Warn("allocation of piece ", req.Data, " not found after transaction ", tx.TxnID, " was executed")
+				continue
+			}
+			tx.Allocations[req.Data.String()] = uint64(aid)
+			logs.GetLogger().Infof("data cap allocate success for piece %s, allocation id: %d", req.Data, aid)
+		}
+	}
+	return nil
+}
+
+// recordMultisigTxs updates the multisig store. Transactions that cannot be
+// recorded have still been proposed, so failures are only logged.
+func (client *Client) recordMultisigTxs(ctx context.Context, update func(store *MultisigStore) error) {
+	store, err := client.MultisigStore()
+	if err == nil {
+		defer store.Close()
+		err = update(store)
+	}
+	if err != nil {
+		logs.GetLogger().Warn("updating multisig store failed: ", err)
+	}
+}
+
+// checkDealClient refuses deal clients that cannot sign deal proposals
+func checkDealClient(ctx context.Context, sapi actorAPI, walletAddr address.Address) error {
+	msig, err := isMultisig(ctx, sapi, walletAddr)
+	if err != nil {
+		return err
+	}
+	if msig {
+		return fmt.Errorf("wallet %s: %w", walletAddr, ErrMultisigDealClient)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filswan/go-swan-lib/logs"
+)
+
+// Outcomes of a replication attempt with a single provider
+const (
+	ReplicaAccepted = "Accepted"
+	ReplicaExisting = "Existing"
+	ReplicaRejected = "Rejected"
+	ReplicaSkipped  = "Skipped"
+	ReplicaFailed   = "Failed"
+)
+
+// ReplicationRequest describes a piece that should be stored by several providers
+type ReplicationRequest struct {
+	Deal       DealParam       // deal parameters shared by all replicas, the Provider field is ignored
+	Candidates []string        // storage providers, best first; duplicates are tried once
+	Replicas   int             // number of accepted deals wanted for the piece
+	MaxPrice   abi.TokenAmount // max ask price in attoFIL per epoch per GiB; no limit if nil or zero
+}
+
+// ReplicaOutcome is the result of a replication attempt with a single provider
+type ReplicaOutcome struct {
+	Provider string
+	Status   string
+	DealUuid string
+	Price    abi.TokenAmount // ask price in attoFIL per epoch per GiB, if the ask was queried
+	Reason   string
+}
+
+type ReplicationReport struct {
+	PieceCid string
+	Replicas int
+	Accepted int // accepted deals, including deals that existed before the replication
+	Outcomes []ReplicaOutcome
+}
+
+// Replicator sends the same piece to several providers. A Replicator can be
+// shared between concurrent replications to limit the number of proposals in
+// flight to each provider.
+type Replicator struct {
+	client           *Client
+	perProviderLimit int
+
+	lk    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewReplicator returns a Replicator which sends at most perProviderLimit
+// proposals concurrently to each provider
+func (client *Client) NewReplicator(perProviderLimit int) *Replicator {
+	if perProviderLimit <= 0 {
+		perProviderLimit = 1
+	}
+	return &Replicator{
+		client:           client,
+		perProviderLimit: perProviderLimit,
+		slots:            make(map[string]chan struct{}),
+	}
+}
+
+// Replicate sends the piece to the candidate providers, in order, until the
+// requested number of replicas has been accepted
+func (client *Client) Replicate(ctx context.Context, req ReplicationRequest) (*ReplicationReport, error) {
+	return client.NewReplicator(1).Replicate(ctx, req)
+}
+
+// Replicate tries the candidate providers in order: providers whose ask is out
+// of the price or piece size range are skipped, and every rejection moves on to
+// the next candidate until the requested number of deals has been accepted.
+// Deals already accepted for the piece count towards the replicas.
+func (r *Replicator) Replicate(ctx context.Context, req ReplicationRequest) (*ReplicationReport, error) {
+	if req.Replicas <= 0 {
+		return nil, fmt.Errorf("replicas must be greater than 0")
+	}
+
+	report := &ReplicationReport{
+		PieceCid: req.Deal.Commp,
+		Replicas: req.Replicas,
+	}
+
+	existing, err := r.existingReplicas(ctx, req.Deal.Commp)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, c := range req.Candidates {
+		maddr, err := ParseAddress(c)
+		if err != nil {
+			report.Outcomes = append(report.Outcomes, ReplicaOutcome{Provider: c, Status: ReplicaFailed, Reason: err.Error()})
+			continue
+		}
+		provider := maddr.String()
+		if seen[provider] {
+			continue
+		}
+		seen[provider] = true
+
+		if dealUuid, ok := existing[provider]; ok {
+			report.Outcomes = append(report.Outcomes, ReplicaOutcome{Provider: provider, Status: ReplicaExisting, DealUuid: dealUuid})
+			report.Accepted++
+			continue
+		}
+		candidates = append(candidates, provider)
+	}
+
+	results := make(chan ReplicaOutcome)
+	next, inflight := 0, 0
+	launch := func() {
+		for report.Accepted+inflight < req.Replicas && next < len(candidates) {
+			provider := candidates[next]
+			next++
+			inflight++
+			go func() {
+				results <- r.tryProvider(ctx, req, provider)
+			}()
+		}
+	}
+
+	launch()
+	for inflight > 0 {
+		outcome := <-results
+		inflight--
+		report.Outcomes = append(report.Outcomes, outcome)
+		if outcome.Status == ReplicaAccepted {
+			report.Accepted++
+		}
+		if ctx.Err() == nil {
+			launch()
+		}
+	}
+
+	if report.Accepted < req.Replicas {
+		return report, fmt.Errorf("piece %s: only %d of %d replicas accepted after trying %d providers", req.Deal.Commp, report.Accepted, req.Replicas, next)
+	}
+	return report, nil
+}
+
+func (r *Replicator) existingReplicas(ctx context.Context, pieceCid string) (map[string]string, error) {
+	dealDB, err := r.client.DealDB()
+	if err != nil {
+		return nil, fmt.Errorf("opening deal db: %w", err)
+	}
+	defer dealDB.Close()
+
+	deals, err := dealDB.ListByPieceCid(ctx, pieceCid)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]string)
+	for _, deal := range deals {
+		if !isLiveDealState(deal.State) {
+			continue
+		}
+		provider := deal.Provider
+		if maddr, err := ParseAddress(provider); err == nil {
+			provider = maddr.String()
+		}
+		existing[provider] = deal.ID.String()
+	}
+	return existing, nil
+}
+
+func (r *Replicator) tryProvider(ctx context.Context, req ReplicationRequest, provider string) ReplicaOutcome {
+	outcome := ReplicaOutcome{Provider: provider}
+
+	release, err := r.acquire(ctx, provider)
+	if err != nil {
+		outcome.Status, outcome.Reason = ReplicaFailed, err.Error()
+		return outcome
+	}
+	defer release()
+
+	ask, err := r.client.StorageAsk(provider, 0, 0)
+	if err != nil {
+		outcome.Status, outcome.Reason = ReplicaFailed, fmt.Sprintf("querying ask: %s", err)
+		return outcome
+	}
+
+	outcome.Price = ask.Price
+	if req.Deal.Verified {
+		outcome.Price = ask.VerifiedPrice
+	}
+
+	pieceSize := abi.PaddedPieceSize(req.Deal.PieceSize)
+	if pieceSize < ask.MinPieceSize || pieceSize > ask.MaxPieceSize {
+		outcome.Status = ReplicaSkipped
+		outcome.Reason = fmt.Sprintf("piece size %d is outside of the provider's range [%d,%d]", pieceSize, ask.MinPieceSize, ask.MaxPieceSize)
+		return outcome
+	}
+
+	if !req.MaxPrice.Nil() && !req.MaxPrice.IsZero() && big.Cmp(outcome.Price, req.MaxPrice) > 0 {
+		outcome.Status = ReplicaSkipped
+		outcome.Reason = fmt.Sprintf("ask price %s is above the max price %s", outcome.Price, req.MaxPrice)
+		return outcome
+	}
+
+	dealP := req.Deal
+	dealP.Provider = provider
+	if dealP.StoragePrice.Nil() || dealP.StoragePrice.IsZero() {
+		dealP.StoragePrice = outcome.Price
+	}
+
+	dealUuid, err := r.client.sendDealToMiner(dealP, nil)
+	switch {
+	case errors.Is(err, ErrDealRejected):
+		outcome.Status, outcome.Reason = ReplicaRejected, err.Error()
+	case err != nil:
+		outcome.Status, outcome.Reason = ReplicaFailed, err.Error()
+	default:
+		outcome.Status, outcome.DealUuid = ReplicaAccepted, dealUuid
+	}
+
+	logs.GetLogger().Info("piece: ", req.Deal.Commp, ", provider: ", provider, ", replica: ", outcome.Status, " ", outcome.Reason)
+	return outcome
+}
+
+// acquire waits for a free proposal slot for the provider
+func (r *Replicator) acquire(ctx context.Context, provider string) (func(), error) {
+	r.lk.Lock()
+	slot, ok := r.slots[provider]
+	if !ok {
+		slot = make(chan struct{}, r.perProviderLimit)
+		r.slots[provider] = slot
+	}
+	r.lk.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
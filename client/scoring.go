@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	mbig "math/big"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+)
+
+// ScoreWeights sets how much each criterion contributes to a provider score.
+// Each criterion is scored between 0 and 1 before being weighted.
+type ScoreWeights struct {
+	Price       float64 // lower ask price scores higher
+	Power       float64 // higher quality adjusted power scores higher
+	Faults      float64 // lower share of faulty sectors scores higher
+	SuccessRate float64 // higher share of successful deals in our deal history scores higher
+}
+
+var DefaultScoreWeights = ScoreWeights{
+	Price:       0.4,
+	Power:       0.2,
+	Faults:      0.2,
+	SuccessRate: 0.2,
+}
+
+// neutralSuccessRate is the success rate used for providers without deal history
+const neutralSuccessRate = 0.5
+
+type ScoreRequest struct {
+	PieceSize  abi.PaddedPieceSize
+	Verified   bool
+	Candidates []string      // providers to score; every miner with power if empty
+	Weights    *ScoreWeights // DefaultScoreWeights if nil
+	AskOptions AskCrawlOptions
+}
+
+// ProviderScore is the score of a provider for a piece, with the data it is
+// computed from and the reasons for its rank
+type ProviderScore struct {
+	Miner           string
+	Eligible        bool
+	Score           float64
+	Price           abi.TokenAmount // ask price for the deal type, attoFIL per epoch per GiB
+	QualityAdjPower abi.StoragePower
+	SectorSize      abi.SectorSize
+	FaultySectors   uint64
+	FaultRatio      float64
+	Deals           int     // deals with the provider that reached a final outcome
+	SuccessRate     float64 // share of Deals that became active
+	Reasons         []string
+}
+
+// ScoreProviders ranks the candidate providers for a piece. Providers whose
+// ask cannot be queried, or whose piece size range or sector size does not fit
+// the piece, are not eligible and are ranked last.
+func (client *Client) ScoreProviders(ctx context.Context, req ScoreRequest) ([]*ProviderScore, error) {
+	weights := DefaultScoreWeights
+	if req.Weights != nil {
+		weights = *req.Weights
+	}
+
+	asks, err := client.CrawlAsks(ctx, req.Candidates, req.AskOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	fullNode, closer, err := client.GetLotusFullNodeApi()
+	if err != nil {
+		return nil, fmt.Errorf("cant setup fullnode connection: %w", err)
+	}
+	defer closer()
+
+	dealDB, err := client.DealDB()
+	if err != nil {
+		return nil, fmt.Errorf("opening deal db: %w", err)
+	}
+	defer dealDB.Close()
+
+	var scores []*ProviderScore
+	for _, ask := range asks {
+		score := &ProviderScore{Miner: ask.Miner}
+		scores = append(scores, score)
+
+		if ask.Error != "" {
+			score.Reasons = append(score.Reasons, fmt.Sprintf("ask query failed: %s", ask.Error))
+			continue
+		}
+
+		score.Price = ask.Price
+		if req.Verified {
+			score.Price = ask.VerifiedPrice
+		}
+
+		if req.PieceSize < ask.MinPieceSize || req.PieceSize > ask.MaxPieceSize {
+			score.Reasons = append(score.Reasons, fmt.Sprintf("piece size %d is outside of the ask range [%d,%d]", req.PieceSize, ask.MinPieceSize, ask.MaxPieceSize))
+			continue
+		}
+
+		if err := loadChainScoreData(ctx, fullNode, score); err != nil {
+			score.Reasons = append(score.Reasons, err.Error())
+			continue
+		}
+
+		if abi.PaddedPieceSize(score.SectorSize) < req.PieceSize {
+			score.Reasons = append(score.Reasons, fmt.Sprintf("piece size %d is larger than the sector size %d", req.PieceSize, score.SectorSize))
+			continue
+		}
+
+		if err := loadHistoryScoreData(ctx, dealDB, score); err != nil {
+			return nil, err
+		}
+		score.Eligible = true
+	}
+
+	rankProviders(scores, weights)
+	return scores, nil
+}
+
+func loadChainScoreData(ctx context.Context, fullNode api.FullNode, score *ProviderScore) error {
+	maddr, err := ParseAddress(score.Miner)
+	if err != nil {
+		return err
+	}
+
+	power, err := fullNode.StateMinerPower(ctx, maddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting miner power: %w", err)
+	}
+	score.QualityAdjPower = power.MinerPower.QualityAdjPower
+
+	info, err := fullNode.StateMinerInfo(ctx, maddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting miner info: %w", err)
+	}
+	score.SectorSize = info.SectorSize
+
+	faults, err := fullNode.StateMinerFaults(ctx, maddr, chaintypes.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("getting miner faults: %w", err)
+	}
+	score.FaultySectors, err = faults.Count()
+	if err != nil {
+		return fmt.Errorf("counting miner faults: %w", err)
+	}
+
+	if score.SectorSize > 0 {
+		sectors := big.Div(power.MinerPower.RawBytePower, big.NewIntUnsigned(uint64(score.SectorSize)))
+		if sectors.GreaterThan(big.Zero()) {
+			score.FaultRatio = math.Min(1, float64(score.FaultySectors)/float64(sectors.Uint64()))
+		} else if score.FaultySectors > 0 {
+			score.FaultRatio = 1
+		}
+	}
+	return nil
+}
+
+func loadHistoryScoreData(ctx context.Context, dealDB *DealDB, score *ProviderScore) error {
+	deals, err := dealDB.ListByProvider(ctx, score.Miner)
+	if err != nil {
+		return err
+	}
+
+	succeeded := 0
+	for _, deal := range deals {
+		switch deal.State {
+		case DealStateActive:
+			succeeded++
+			score.Deals++
+		case DealStateExpired:
+			// deals also expire when they reach their end epoch; only those
+			// that were never activated failed
+			info, err := dealDB.ChainInfo(ctx, deal.ID)
+			if err != nil {
+				return err
+			}
+			if info.SectorStartEpoch >= 0 {
+				succeeded++
+			}
+			score.Deals++
+		case DealStateRejected, DealStateError, DealStateSlashed:
+			score.Deals++
+		}
+	}
+
+	score.SuccessRate = neutralSuccessRate
+	if score.Deals > 0 {
+		score.SuccessRate = float64(succeeded) / float64(score.Deals)
+	}
+	return nil
+}
+
+// rankProviders computes the weighted score of the eligible providers and
+// sorts all providers by rank
+func rankProviders(scores []*ProviderScore, weights ScoreWeights) {
+	var eligible []*ProviderScore
+	for _, s := range scores {
+		if s.Eligible {
+			eligible = append(eligible, s)
+		}
+	}
+
+	if len(eligible) > 0 {
+		minPrice, maxPrice := eligible[0].Price, eligible[0].Price
+		maxPower := eligible[0].QualityAdjPower
+		for _, s := range eligible[1:] {
+			minPrice = big.Min(minPrice, s.Price)
+			maxPrice = big.Max(maxPrice, s.Price)
+			maxPower = big.Max(maxPower, s.QualityAdjPower)
+		}
+
+		for _, s := range eligible {
+			priceScore := 1.0
+			if priceRange := big.Sub(maxPrice, minPrice); priceRange.GreaterThan(big.Zero()) {
+				priceScore = bigRatio(big.Sub(maxPrice, s.Price), priceRange)
+			}
+			powerScore := 1.0
+			if maxPower.GreaterThan(big.Zero()) {
+				powerScore = bigRatio(s.QualityAdjPower, maxPower)
+			}
+			faultScore := 1 - s.FaultRatio
+
+			s.Score = weights.Price*priceScore + weights.Power*powerScore + weights.Faults*faultScore + weights.SuccessRate*s.SuccessRate
+			s.Reasons = append(s.Reasons,
+				fmt.Sprintf("price %s/GiB/epoch scores %.2f", chaintypes.FIL(s.Price), priceScore),
+				fmt.Sprintf("quality adjusted power %s scores %.2f", chaintypes.SizeStr(s.QualityAdjPower), powerScore),
+				fmt.Sprintf("%d faulty sectors (%.2f%%) scores %.2f", s.FaultySectors, s.FaultRatio*100, faultScore))
+			if s.Deals > 0 {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("%.0f%% of %d past deals succeeded", s.SuccessRate*100, s.Deals))
+			} else {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("no deal history, success rate counted as %.2f", neutralSuccessRate))
+			}
+		}
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Eligible != scores[j].Eligible {
+			return scores[i].Eligible
+		}
+		return scores[i].Score > scores[j].Score
+	})
+
+	for i, s := range scores {
+		if s.Eligible {
+			logs.GetLogger().Debug("rank ", i+1, ", miner: ", s.Miner, ", score: ", s.Score)
+		}
+	}
+}
+
+func bigRatio(num, denom big.Int) float64 {
+	f, _ := new(mbig.Float).Quo(new(mbig.Float).SetInt(num.Int), new(mbig.Float).SetInt(denom.Int)).Float64()
+	return f
+}
+
+// RankedMiners returns the eligible providers, best first, e.g. as the
+// candidates of a ReplicationRequest
+func RankedMiners(scores []*ProviderScore) []string {
+	var miners []string
+	for _, s := range scores {
+		if s.Eligible {
+			miners = append(miners, s.Miner)
+		}
+	}
+	return miners
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	clinode "github.com/filecoin-project/boost/cli/node"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
+)
+
+// Signer signs deal proposals, deal status requests and chain messages for the
+// client wallets. The wallet of the client repo is the default signer.
+type Signer interface {
+	WalletHas(ctx context.Context, addr address.Address) (bool, error)
+	WalletSign(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) (*crypto.Signature, error)
+}
+
+var _ Signer = (*wallet.LocalWallet)(nil)
+
+// WithSigner sets the signer used instead of the wallet of the client repo
+func (client *Client) WithSigner(signer Signer) *Client {
+	client.signer = signer
+	return client
+}
+
+// nodeSigner returns the signer of the client, or the wallet of the node if
+// none was set
+func (client *Client) nodeSigner(n *clinode.Node) Signer {
+	if client.signer != nil {
+		return client.signer
+	}
+	return n.Wallet
+}
+
+// dealWallet returns the wallet signing the deal: the provided wallet, or the
+// default wallet of the client repo
+func (client *Client) dealWallet(ctx context.Context, n *clinode.Node, provided string) (address.Address, error) {
+	var walletAddr address.Address
+	if provided != "" {
+		var err error
+		if walletAddr, err = ParseAddress(provided); err != nil {
+			return address.Undef, err
+		}
+	}
+
+	if client.signer == nil {
+		if walletAddr == address.Undef {
+			return n.GetProvidedOrDefaultWallet(ctx, "")
+		}
+		return n.GetProvidedOrDefaultWallet(ctx, walletAddr.String())
+	}
+
+	if walletAddr == address.Undef {
+		return address.Undef, errors.New("the wallet is required with an external signer")
+	}
+	has, err := client.signer.WalletHas(ctx, walletAddr)
+	if err != nil {
+		return address.Undef, err
+	}
+	if !has {
+		return address.Undef, fmt.Errorf("the signer has no key for wallet %s", walletAddr)
+	}
+	return walletAddr, nil
+}
+
+// signerWallet is a Signer used as the wallet of the lotus message signer;
+// only signing is supported
+type signerWallet struct {
+	Signer
+}
+
+var _ api.Wallet = signerWallet{}
+
+var errSignerOnly = errors.New("not supported by the signer")
+
+func (signerWallet) WalletNew(context.Context, chaintypes.KeyType) (address.Address, error) {
+	return address.Undef, errSignerOnly
+}
+
+func (signerWallet) WalletList(context.Context) ([]address.Address, error) {
+	return nil, errSignerOnly
+}
+
+func (signerWallet) WalletExport(context.Context, address.Address) (*chaintypes.KeyInfo, error) {
+	return nil, errSignerOnly
+}
+
+func (signerWallet) WalletImport(context.Context, *chaintypes.KeyInfo) (address.Address, error) {
+	return address.Undef, errSignerOnly
+}
+
+func (signerWallet) WalletDelete(context.Context, address.Address) error {
+	return errSignerOnly
+}
+
+// ApprovalPolicy decides whether a signing request is sent to the signer
+type ApprovalPolicy interface {
+	Approve(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) error
+}
+
+// MsgTypePolicy approves signing requests of the given message types
+type MsgTypePolicy struct {
+	Allowed []api.MsgType
+}
+
+func (p MsgTypePolicy) Approve(_ context.Context, _ address.Address, _ []byte, meta api.MsgMeta) error {
+	for _, t := range p.Allowed {
+		if meta.Type == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("signing %q requests is not allowed", meta.Type)
+}
+
+// ChainMessagePolicy checks the chain messages to sign: the message in the
+// metadata must match the signed bytes, send at most MaxValue and call one of
+// the allowed methods. Other signing requests are approved.
+type ChainMessagePolicy struct {
+	MaxValue abi.TokenAmount // no limit if nil
+	Methods  []abi.MethodNum // any method if empty
+}
+
+func (p ChainMessagePolicy) Approve(_ context.Context, addr address.Address, msg []byte, meta api.MsgMeta) error {
+	if meta.Type != api.MTChainMsg {
+		return nil
+	}
+	cmsg, err := chaintypes.DecodeMessage(meta.Extra)
+	if err != nil {
+		return fmt.Errorf("decoding chain message to sign: %w", err)
+	}
+	sb, err := messagesigner.SigningBytes(cmsg, cmsg.From.Protocol())
+	if err != nil {
+		return fmt.Errorf("getting signing bytes of chain message: %w", err)
+	}
+	if !bytes.Equal(sb, msg) {
+		return errors.New("the chain message does not match the signed bytes")
+	}
+	if cmsg.From != addr {
+		return fmt.Errorf("the chain message is from %s, not from the signing wallet %s", cmsg.From, addr)
+	}
+	if !p.MaxValue.Nil() && big.Cmp(cmsg.Value, p.MaxValue) > 0 {
+		return fmt.Errorf("the chain message sends %s, more than the limit of %s", chaintypes.FIL(cmsg.Value), chaintypes.FIL(p.MaxValue))
+	}
+	if len(p.Methods) == 0 {
+		return nil
+	}
+	for _, m := range p.Methods {
+		if cmsg.Method == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("signing calls of method %d to %s is not allowed", cmsg.Method, cmsg.To)
+}
+
+// AllPolicies approves the signing requests approved by all the policies
+type AllPolicies []ApprovalPolicy
+
+func (ps AllPolicies) Approve(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) error {
+	for _, p := range ps {
+		if err := p.Approve(ctx, addr, msg, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signerAPI are the wallet methods of the lotus JSON-RPC API used to sign,
+// as served by lotus-wallet
+type signerAPI struct {
+	Internal struct {
+		WalletHas  func(ctx context.Context, addr address.Address) (bool, error)
+		WalletSign func(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) (*crypto.Signature, error)
+	}
+}
+
+// RemoteSigner sends signing requests to an external signing service speaking
+// the wallet methods of the lotus JSON-RPC API, such as lotus-wallet. Requests
+// are checked against the approval policy before they are sent.
+type RemoteSigner struct {
+	api    signerAPI
+	policy ApprovalPolicy
+}
+
+var _ Signer = (*RemoteSigner)(nil)
+
+// NewRemoteSigner connects to the signing service at url, e.g.
+// http://127.0.0.1:1777/rpc/v0. A nil policy approves all requests.
+func NewRemoteSigner(ctx context.Context, url, token string, policy ApprovalPolicy) (*RemoteSigner, jsonrpc.ClientCloser, error) {
+	var headers http.Header
+	if token != "" {
+		headers = http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+
+	s := &RemoteSigner{policy: policy}
+	closer, err := jsonrpc.NewMergeClient(ctx, url, "Filecoin", []interface{}{&s.api.Internal}, headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to signer %s: %w", url, err)
+	}
+	return s, closer, nil
+}
+
+func (s *RemoteSigner) WalletHas(ctx context.Context, addr address.Address) (bool, error) {
+	return s.api.Internal.WalletHas(ctx, addr)
+}
+
+func (s *RemoteSigner) WalletSign(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) (*crypto.Signature, error) {
+	if s.policy != nil {
+		if err := s.policy.Approve(ctx, addr, msg, meta); err != nil {
+			return nil, fmt.Errorf("signing request for %s not approved: %w", addr, err)
+		}
+	}
+	sig, err := s.api.Internal.WalletSign(ctx, addr, msg, meta)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	return sig, nil
+}
+
+// NewSignerHandler serves the signer with the API RemoteSigner speaks, e.g. to
+// stand in for the signing service with a local wallet
+func NewSignerHandler(signer Signer) http.Handler {
+	rpcServer := jsonrpc.NewServer()
+	rpcServer.Register("Filecoin", &signerService{signer: signer})
+	return rpcServer
+}
+
+type signerService struct {
+	signer Signer
+}
+
+func (s *signerService) WalletHas(ctx context.Context, addr address.Address) (bool, error) {
+	return s.signer.WalletHas(ctx, addr)
+}
+
+func (s *signerService) WalletSign(ctx context.Context, addr address.Address, msg []byte, meta api.MsgMeta) (*crypto.Signature, error) {
+	return s.signer.WalletSign(ctx, addr, msg, meta)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet"
+	"github.com/filecoin-project/lotus/lib/sigs"
+)
+
+// TestRemoteSigner signs through a local wallet served as a stand-in for the
+// signing service, and checks that the approval policy rejects requests
+func TestRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+
+	w, err := wallet.NewWallet(wallet.NewMemKeyStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := w.WalletNew(ctx, chaintypes.KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewSignerHandler(w))
+	defer srv.Close()
+
+	policy := AllPolicies{
+		MsgTypePolicy{Allowed: []api.MsgType{api.MTDealProposal, api.MTChainMsg}},
+		ChainMessagePolicy{MaxValue: abi.NewTokenAmount(1000), Methods: []abi.MethodNum{0}},
+	}
+	signer, closer, err := NewRemoteSigner(ctx, srv.URL, "", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	has, err := signer.WalletHas(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Fatalf("signer has no key for wallet %s", addr)
+	}
+
+	proposal := []byte("deal proposal")
+	sig, err := signer.WalletSign(ctx, addr, proposal, api.MsgMeta{Type: api.MTDealProposal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sigs.Verify(sig, addr, proposal); err != nil {
+		t.Fatalf("invalid signature of the deal proposal: %s", err)
+	}
+
+	if _, err := signer.WalletSign(ctx, addr, proposal, api.MsgMeta{Type: api.MTUnknown}); err == nil {
+		t.Fatal("signing a request of a type that is not allowed succeeded")
+	}
+
+	signMsg := func(msg *chaintypes.Message, signed []byte) error {
+		mb, err := msg.ToStorageBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signed == nil {
+			if signed, err = messagesigner.SigningBytes(msg, msg.From.Protocol()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = signer.WalletSign(ctx, addr, signed, api.MsgMeta{Type: api.MTChainMsg, Extra: mb.RawData()})
+		return err
+	}
+	msg := func(value int64, method abi.MethodNum) *chaintypes.Message {
+		return &chaintypes.Message{From: addr, To: addr, Value: big.NewInt(value), Method: method, GasFeeCap: big.Zero(), GasPremium: big.Zero()}
+	}
+
+	if err := signMsg(msg(1000, 0), nil); err != nil {
+		t.Fatalf("signing an allowed chain message failed: %s", err)
+	}
+	if err := signMsg(msg(1001, 0), nil); err == nil {
+		t.Fatal("signing a chain message above the value limit succeeded")
+	}
+	if err := signMsg(msg(0, 2), nil); err == nil {
+		t.Fatal("signing a chain message calling a method that is not allowed succeeded")
+	}
+	if err := signMsg(msg(0, 0), []byte("other bytes")); err == nil {
+		t.Fatal("signing bytes that do not match the chain message succeeded")
+	}
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/lotus/build"
+	chaintypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filswan/go-swan-lib/logs"
+)
+
+const (
+	// DefaultStartEpochDelay is the delay of the start epoch after the chain
+	// head for providers with no known sealing delay
+	DefaultStartEpochDelay = builtin.EpochsInDay * 2
+	// DefaultMinDelaySamples is the number of activated deals needed to learn
+	// the sealing delay of a provider
+	DefaultMinDelaySamples = 3
+
+	maxDelaySamples = 50
+)
+
+// StartEpochPlanner plans the start epoch of deals from the sealing delay of
+// the provider: the delay learned from the activation of past deals with the
+// provider if there are enough of them, else the delay advertised by the
+// provider, else the default delay. The delay for the piece size and the safety
+// margin are added to it.
+type StartEpochPlanner struct {
+	DefaultDelay   abi.ChainEpoch            // DefaultStartEpochDelay if 0
+	ProviderDelays map[string]abi.ChainEpoch // sealing delay advertised by provider address, in any form ParseAddress accepts
+	DelayPerGiB    abi.ChainEpoch            // added per GiB of piece, for the transfer and sealing of big pieces
+	Margin         abi.ChainEpoch            // safety margin added to the delay
+	MinSamples     int                       // DefaultMinDelaySamples if 0
+}
+
+// Delay returns the number of epochs after the chain head the deal should start
+// at. learned are the activation delays of past deals with the provider.
+func (p *StartEpochPlanner) Delay(provider string, pieceSize abi.PaddedPieceSize, learned []abi.ChainEpoch) abi.ChainEpoch {
+	minSamples := p.MinSamples
+	if minSamples <= 0 {
+		minSamples = DefaultMinDelaySamples
+	}
+
+	delay := p.DefaultDelay
+	if delay <= 0 {
+		delay = DefaultStartEpochDelay
+	}
+	if advertised := p.advertisedDelay(provider); advertised > 0 {
+		delay = advertised
+	}
+	if len(learned) >= minSamples {
+		// most deals with the provider were activated within the 90th percentile
+		sorted := append([]abi.ChainEpoch(nil), learned...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		delay = sorted[(len(sorted)-1)*9/10]
+	}
+
+	gib := (uint64(pieceSize) + (1 << 30) - 1) >> 30
+	return delay + p.DelayPerGiB*abi.ChainEpoch(gib) + p.Margin
+}
+
+// advertisedDelay returns the delay advertised by the provider, or 0. The same
+// provider can be given as f0, t0 or 0x address.
+func (p *StartEpochPlanner) advertisedDelay(provider string) abi.ChainEpoch {
+	maddr, err := ParseAddress(provider)
+	if err != nil {
+		return 0
+	}
+	for a, delay := range p.ProviderDelays {
+		if paddr, err := ParseAddress(a); err == nil && paddr == maddr {
+			return delay
+		}
+	}
+	return 0
+}
+
+// WithStartEpochPlanner sets the planner of the start epoch of deals that do
+// not set one. By default, deals start DefaultStartEpochDelay after the head.
+func (client *Client) WithStartEpochPlanner(planner *StartEpochPlanner) *Client {
+	client.startPlanner = planner
+	return client
+}
+
+// PlanStartEpoch returns the start epoch planned for a deal of the given piece
+// size with the provider
+func (client *Client) PlanStartEpoch(ctx context.Context, provider string, pieceSize abi.PaddedPieceSize) (abi.ChainEpoch, error) {
+	gapi, closer, err := client.getGatewayApi(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer closer()
+
+	head, err := gapi.ChainHead(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get chain head: %w", err)
+	}
+	return client.dealStartEpoch(ctx, head, DealParam{Provider: provider, PieceSize: uint64(pieceSize)})
+}
+
+// dealStartEpoch returns the start epoch of the deal: the given start epoch,
+// offset from the head or start time, or the planned start epoch by default
+func (client *Client) dealStartEpoch(ctx context.Context, head *chaintypes.TipSet, dealP DealParam) (abi.ChainEpoch, error) {
+	set := 0
+	for _, isSet := range []bool{dealP.StartEpoch != 0, dealP.StartEpochHeadOffset != 0, dealP.StartTime != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return 0, errors.New("only one flag from `start-epoch-head-offset', `start-epoch` or `start-time` can be specified")
+	}
+
+	switch {
+	case dealP.StartEpochHeadOffset != 0:
+		return head.Height() + abi.ChainEpoch(dealP.StartEpochHeadOffset), nil
+	case dealP.StartEpoch != 0:
+		return abi.ChainEpoch(dealP.StartEpoch), nil
+	case dealP.StartTime != nil:
+		startEpoch := EpochAtTime(head, *dealP.StartTime)
+		if startEpoch <= head.Height() {
+			return 0, fmt.Errorf("start time %s is not after the current head %d", dealP.StartTime, head.Height())
+		}
+		return startEpoch, nil
+	}
+
+	if client.startPlanner == nil {
+		return head.Height() + DefaultStartEpochDelay, nil
+	}
+
+	maddr, err := ParseAddress(dealP.Provider)
+	if err != nil {
+		return 0, err
+	}
+	learned := client.activationDelays(ctx, maddr.String())
+	return head.Height() + client.startPlanner.Delay(maddr.String(), abi.PaddedPieceSize(dealP.PieceSize), learned), nil
+}
+
+// activationDelays returns the delays between the proposal and the activation
+// of the latest deals with the provider in the deal db of the client repo, for
+// StartEpochPlanner.Delay. The planner falls back to the advertised delays
+// without this history, so failing to read it is only logged.
+func (client *Client) activationDelays(ctx context.Context, provider string) []abi.ChainEpoch {
+	dealDB, err := client.DealDB()
+	if err != nil {
+		logs.GetLogger().Warn("opening deal db: ", err)
+		return nil
+	}
+	defer dealDB.Close()
+
+	delays, err := dealDB.ActivationDelays(ctx, provider, maxDelaySamples)
+	if err != nil {
+		logs.GetLogger().Warn("reading activation delays of provider ", provider, ": ", err)
+		return nil
+	}
+	var learned []abi.ChainEpoch
+	for _, delay := range delays {
+		learned = append(learned, abi.ChainEpoch(delay))
+	}
+	return learned
+}
+
+// EpochAtTime converts a date to the epoch mined at that time, from the
+// timestamp of the head and the network block time
+func EpochAtTime(head *chaintypes.TipSet, t time.Time) abi.ChainEpoch {
+	elapsed := t.Unix() - int64(head.MinTimestamp())
+	return head.Height() + abi.ChainEpoch(elapsed/int64(build.BlockDelaySecs))
+}
+
+// TimeAtEpoch converts an epoch to the time it is mined at, from the
+// timestamp of the head and the network block time
+func TimeAtEpoch(head *chaintypes.TipSet, epoch abi.ChainEpoch) time.Time {
+	elapsed := int64(epoch-head.Height()) * int64(build.BlockDelaySecs)
+	return time.Unix(int64(head.MinTimestamp())+elapsed, 0)
+}
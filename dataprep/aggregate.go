@@ -0,0 +1,254 @@
+package dataprep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	"github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+)
+
+// maxAggregatePieceSize is the largest aggregate piece that is tried when no
+// piece size is given, the biggest sector size
+const maxAggregatePieceSize = abi.PaddedPieceSize(64 << 30)
+
+// SubPiece is a CAR packed into an aggregate, with its proof of inclusion
+type SubPiece struct {
+	CarInfo
+	Offset uint64 `json:"offset"` // unpadded offset of the sub piece in the aggregate
+	Proof  []byte `json:"proof"`  // CBOR encoded datasegment.InclusionProof
+}
+
+// InclusionProof decodes the proof that the sub piece is included in the
+// aggregate piece
+func (s *SubPiece) InclusionProof() (*datasegment.InclusionProof, error) {
+	var proof datasegment.InclusionProof
+	if err := proof.UnmarshalCBOR(bytes.NewReader(s.Proof)); err != nil {
+		return nil, fmt.Errorf("decoding inclusion proof of %s: %w", s.PieceCid, err)
+	}
+	return &proof, nil
+}
+
+// AggregateInfo is a piece made of many small CARs in the data segment (PoDSI)
+// format. The CarInfo of the aggregate describes the aggregate file; as the
+// aggregate is not a CAR, its payload cid is the aggregate piece cid.
+type AggregateInfo struct {
+	CarInfo
+	IndexPieceCid cid.Cid    `json:"index_piece_cid"` // piece cid of the data segment index
+	SubPieces     []SubPiece `json:"sub_pieces"`
+}
+
+// Aggregate packs the CARs into a single data segment aggregate written to
+// outPath, and collects the inclusion proof of each CAR. pieceSize is the size
+// of the aggregate piece, e.g. the provider's min piece size; if it is 0, the
+// smallest piece the CARs and the segment index fit in is used. The aggregate
+// is dealt like a CAR, with DealParam.WithCar(&aggregate.CarInfo).
+func Aggregate(ctx context.Context, cars []*CarInfo, pieceSize abi.PaddedPieceSize, outPath string) (*AggregateInfo, error) {
+	if len(cars) == 0 {
+		return nil, fmt.Errorf("no cars to aggregate")
+	}
+
+	// place the biggest pieces first, so that they are aligned without gaps
+	cars = append([]*CarInfo(nil), cars...)
+	sort.SliceStable(cars, func(i, j int) bool { return cars[i].PieceSize > cars[j].PieceSize })
+
+	var subdeals []abi.PieceInfo
+	var total abi.PaddedPieceSize
+	for _, car := range cars {
+		subdeals = append(subdeals, abi.PieceInfo{Size: car.PieceSize, PieceCID: car.PieceCid})
+		total += car.PieceSize
+	}
+
+	agg, err := newAggregate(pieceSize, total, subdeals)
+	if err != nil {
+		return nil, err
+	}
+
+	aggCid, err := agg.PieceCID()
+	if err != nil {
+		return nil, err
+	}
+	indexCid, err := agg.IndexPieceCID()
+	if err != nil {
+		return nil, err
+	}
+
+	carSize, err := writeAggregate(ctx, agg, cars, aggCid, outPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &AggregateInfo{
+		CarInfo: CarInfo{
+			CarPath:    outPath,
+			PayloadCid: aggCid,
+			PieceCid:   aggCid,
+			PieceSize:  agg.DealSize,
+			CarSize:    carSize,
+		},
+		IndexPieceCid: indexCid,
+	}
+
+	for i, car := range cars {
+		proof, err := agg.ProofForIndexEntry(i)
+		if err != nil {
+			return nil, fmt.Errorf("collecting inclusion proof of %s: %w", car.PieceCid, err)
+		}
+
+		// check the proof before handing it out
+		aux, err := proof.ComputeExpectedAuxData(datasegment.InclusionVerifierData{CommPc: car.PieceCid, SizePc: car.PieceSize})
+		if err != nil {
+			return nil, fmt.Errorf("verifying inclusion proof of %s: %w", car.PieceCid, err)
+		}
+		if !aux.CommPa.Equals(aggCid) || aux.SizePa != agg.DealSize {
+			return nil, fmt.Errorf("inclusion proof of %s does not match the aggregate %s", car.PieceCid, aggCid)
+		}
+
+		var buf bytes.Buffer
+		if err := proof.MarshalCBOR(&buf); err != nil {
+			return nil, fmt.Errorf("encoding inclusion proof of %s: %w", car.PieceCid, err)
+		}
+		info.SubPieces = append(info.SubPieces, SubPiece{
+			CarInfo: *car,
+			Offset:  agg.Index.Entries[i].UnpaddedOffest(),
+			Proof:   buf.Bytes(),
+		})
+	}
+	return info, nil
+}
+
+func newAggregate(pieceSize, total abi.PaddedPieceSize, subdeals []abi.PieceInfo) (*datasegment.Aggregate, error) {
+	if pieceSize != 0 {
+		agg, err := datasegment.NewAggregate(pieceSize, subdeals)
+		if err != nil {
+			return nil, fmt.Errorf("creating aggregate of %d pieces in a %d piece: %w", len(subdeals), pieceSize, err)
+		}
+		return agg, nil
+	}
+
+	size := abi.PaddedPieceSize(128)
+	for size < total {
+		size <<= 1
+	}
+	var lastErr error
+	for ; size <= maxAggregatePieceSize; size <<= 1 {
+		agg, err := datasegment.NewAggregate(size, subdeals)
+		if err == nil {
+			return agg, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("%d pieces of %d bytes do not fit in an aggregate of up to %d bytes: %w", len(subdeals), total, maxAggregatePieceSize, lastErr)
+}
+
+// writeAggregate streams the sub pieces and the index into the aggregate file
+// and checks that its commp matches the aggregate piece cid
+func writeAggregate(ctx context.Context, agg *datasegment.Aggregate, cars []*CarInfo, aggCid cid.Cid, outPath string) (uint64, error) {
+	var readers []io.Reader
+	for _, car := range cars {
+		r := &lazyFileReader{path: car.CarPath}
+		defer r.Close() //nolint:errcheck
+		readers = append(readers, r)
+	}
+
+	aggReader, err := agg.AggregateObjectReader(readers)
+	if err != nil {
+		return 0, fmt.Errorf("creating aggregate reader: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return 0, fmt.Errorf("creating aggregate file %s: %w", outPath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	cp := new(commp.Calc)
+	size, err := io.Copy(io.MultiWriter(f, cp), aggReader)
+	if err != nil {
+		return 0, fmt.Errorf("writing aggregate file %s: %w", outPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	rawCommP, _, err := cp.Digest()
+	if err != nil {
+		return 0, fmt.Errorf("computing commp of aggregate: %w", err)
+	}
+	written, err := commcid.DataCommitmentV1ToCID(rawCommP)
+	if err != nil {
+		return 0, err
+	}
+	if !written.Equals(aggCid) {
+		return 0, fmt.Errorf("commp %s of the aggregate file does not match the aggregate piece %s", written, aggCid)
+	}
+	return uint64(size), nil
+}
+
+// lazyFileReader opens the file on the first read, so that the aggregate does
+// not hold a file descriptor per sub piece
+type lazyFileReader struct {
+	path string
+	f    *os.File
+}
+
+func (l *lazyFileReader) Read(p []byte) (int, error) {
+	if l.f == nil {
+		f, err := os.Open(l.path)
+		if err != nil {
+			return 0, err
+		}
+		l.f = f
+	}
+	n, err := l.f.Read(p)
+	if err == io.EOF {
+		l.Close() //nolint:errcheck
+	}
+	return n, err
+}
+
+func (l *lazyFileReader) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// WriteManifest writes the mapping of the sub pieces to the aggregate as JSON
+func (a *AggregateInfo) WriteManifest(path string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadAggregateManifest reads a manifest written by AggregateInfo.WriteManifest
+func ReadAggregateManifest(path string) (*AggregateInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a AggregateInfo
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("parsing aggregate manifest %s: %w", path, err)
+	}
+	return &a, nil
+}
@@ -0,0 +1,320 @@
+package dataprep
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+)
+
+const (
+	// estimated CAR bytes added per file: the UnixFS file node and directory entry
+	carOverheadPerFile = 512
+	// estimated CAR bytes added per 1MiB block of file data: the block header
+	// and its link in the parent node
+	carOverheadPerBlock = 128
+	defaultBlockSize    = 1 << 20
+)
+
+// ChunkFile is a file, or a byte range of a file that is too big for a single
+// chunk, stored in a chunk
+type ChunkFile struct {
+	Path     string `json:"path"`      // path relative to the dataset root, with forward slashes
+	FileSize int64  `json:"file_size"` // size of the whole file
+	Offset   int64  `json:"offset"`    // start of the range stored in the chunk
+	Length   int64  `json:"length"`    // length of the range stored in the chunk
+}
+
+// Chunk is a part of a dataset that is stored as a single CAR and deal
+type Chunk struct {
+	Index    int         `json:"index"`
+	Files    []ChunkFile `json:"files"`
+	DataSize int64       `json:"data_size"` // bytes of file data in the chunk
+	Car      *CarInfo    `json:"car,omitempty"`
+}
+
+// Manifest maps the chunks of a dataset to the files they store
+type Manifest struct {
+	Source       string              `json:"source"`
+	MinPieceSize abi.PaddedPieceSize `json:"min_piece_size"`
+	MaxPieceSize abi.PaddedPieceSize `json:"max_piece_size"`
+	Chunks       []*Chunk            `json:"chunks"`
+}
+
+// PlanChunks splits the files under src into chunks whose CARs fit in pieces
+// of at most maxPieceSize, e.g. the smaller of the provider's max piece size
+// and sector size. Files are packed in path order; files bigger than a chunk
+// are split into byte ranges. Chunks that end up smaller than minPieceSize are
+// padded when they are built.
+func PlanChunks(src string, minPieceSize, maxPieceSize abi.PaddedPieceSize) (*Manifest, error) {
+	if err := maxPieceSize.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid max piece size: %w", err)
+	}
+	if minPieceSize > maxPieceSize {
+		return nil, fmt.Errorf("min piece size %d is bigger than max piece size %d", minPieceSize, maxPieceSize)
+	}
+
+	// the CAR has to fit in the unpadded piece, keep a margin for the overhead
+	// estimate and the CAR header
+	budget := int64(maxPieceSize.Unpadded()) * 98 / 100
+	if budget <= carOverheadPerFile+carOverheadPerBlock {
+		return nil, fmt.Errorf("max piece size %d is too small to plan chunks", maxPieceSize)
+	}
+
+	m := &Manifest{Source: src, MinPieceSize: minPieceSize, MaxPieceSize: maxPieceSize}
+	cur := &Chunk{}
+	var curSize int64
+	flush := func() {
+		if len(cur.Files) == 0 {
+			return
+		}
+		cur.Index = len(m.Chunks)
+		m.Chunks = append(m.Chunks, cur)
+		cur = &Chunk{}
+		curSize = 0
+	}
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = filepath.Base(p)
+		}
+		rel = filepath.ToSlash(rel)
+
+		size := info.Size()
+		for offset := int64(0); offset < size || (size == 0 && offset == 0); {
+			free := budget - curSize - carOverheadPerFile
+			length := size - offset
+			if est := estimatedCarSize(length); est > free {
+				fill := maxDataSize(free)
+				if len(cur.Files) > 0 && (est <= budget-carOverheadPerFile || fill == 0) {
+					// the file fits in a chunk of its own, don't split it
+					flush()
+					continue
+				}
+				if fill == 0 {
+					return fmt.Errorf("max piece size %d cannot hold any file data", maxPieceSize)
+				}
+				length = fill
+			}
+
+			cur.Files = append(cur.Files, ChunkFile{Path: rel, FileSize: size, Offset: offset, Length: length})
+			cur.DataSize += length
+			curSize += carOverheadPerFile + estimatedCarSize(length)
+			offset += length
+			if size == 0 {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking %s: %w", src, err)
+	}
+	flush()
+
+	if len(m.Chunks) == 0 {
+		return nil, fmt.Errorf("no files found in %s", src)
+	}
+	return m, nil
+}
+
+// maxDataSize returns the most file data whose estimated CAR size fits in free
+// bytes, ending with a partial block if the space left allows it
+func maxDataSize(free int64) int64 {
+	blocks := free / (defaultBlockSize + carOverheadPerBlock)
+	length := blocks * defaultBlockSize
+	if rest := free - blocks*(defaultBlockSize+carOverheadPerBlock); rest > carOverheadPerBlock {
+		length += rest - carOverheadPerBlock
+	}
+	return length
+}
+
+func estimatedCarSize(length int64) int64 {
+	blocks := (length + defaultBlockSize - 1) / defaultBlockSize
+	return length + blocks*carOverheadPerBlock
+}
+
+// Build writes the CAR of every chunk that has not been built yet into outDir
+// and computes its CommP. Chunks are named chunk-<index>.car.
+func (m *Manifest) Build(ctx context.Context, outDir string) error {
+	for _, chunk := range m.Chunks {
+		if chunk.Car != nil {
+			continue
+		}
+
+		carPath := filepath.Join(outDir, fmt.Sprintf("chunk-%d.car", chunk.Index))
+		car, err := writeCar(ctx, carPath, func(ls *ipld.LinkSystem) (ipld.Link, error) {
+			return buildChunkDag(m.Source, chunk.Files, ls)
+		})
+		if err != nil {
+			return fmt.Errorf("building chunk %d: %w", chunk.Index, err)
+		}
+		car.Source = m.Source
+
+		if car.PieceSize > m.MaxPieceSize {
+			return fmt.Errorf("chunk %d needs a piece of %d bytes, more than the max piece size %d", chunk.Index, car.PieceSize, m.MaxPieceSize)
+		}
+		if car.PieceSize < m.MinPieceSize {
+			if err := car.PadPiece(m.MinPieceSize); err != nil {
+				return fmt.Errorf("padding chunk %d: %w", chunk.Index, err)
+			}
+		}
+		chunk.Car = car
+	}
+	return nil
+}
+
+// PadPiece sets the piece of the CAR to a bigger, zero padded piece. Providers
+// pad the CAR data to the piece size of the deal.
+func (c *CarInfo) PadPiece(pieceSize abi.PaddedPieceSize) error {
+	if err := pieceSize.Validate(); err != nil {
+		return err
+	}
+	if pieceSize <= c.PieceSize {
+		return nil
+	}
+
+	rawCommP, err := commcid.CIDToPieceCommitmentV1(c.PieceCid)
+	if err != nil {
+		return err
+	}
+	padded, err := commp.PadCommP(rawCommP, uint64(c.PieceSize), uint64(pieceSize))
+	if err != nil {
+		return err
+	}
+	c.PieceCid, err = commcid.DataCommitmentV1ToCID(padded)
+	if err != nil {
+		return err
+	}
+	c.PieceSize = pieceSize
+	return nil
+}
+
+// dirNode is a directory of the chunk DAG being built
+type dirNode struct {
+	dirs  map[string]*dirNode
+	files []dagpb.PBLink
+}
+
+// buildChunkDag builds a UnixFS directory tree with the files of a chunk at
+// their path relative to the dataset root
+func buildChunkDag(src string, files []ChunkFile, ls *ipld.LinkSystem) (ipld.Link, error) {
+	srcIsFile := false
+	if info, err := os.Stat(src); err == nil && !info.IsDir() {
+		srcIsFile = true
+	}
+
+	root := &dirNode{dirs: make(map[string]*dirNode)}
+	for _, cf := range files {
+		fsPath := filepath.Join(src, filepath.FromSlash(cf.Path))
+		if srcIsFile {
+			fsPath = src
+		}
+		lnk, size, err := buildFileRange(fsPath, cf.Offset, cf.Length, ls)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, name := path.Split(cf.Path)
+		node := root
+		for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+			if part == "" {
+				continue
+			}
+			child, ok := node.dirs[part]
+			if !ok {
+				child = &dirNode{dirs: make(map[string]*dirNode)}
+				node.dirs[part] = child
+			}
+			node = child
+		}
+
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		if err != nil {
+			return nil, err
+		}
+		node.files = append(node.files, entry)
+	}
+
+	lnk, _, err := root.build(ls)
+	return lnk, err
+}
+
+func (d *dirNode) build(ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	entries := d.files
+	for name, child := range d.dirs {
+		lnk, size, err := child.build(ls)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, int64(size), lnk)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FieldName().Must().String() < entries[j].FieldName().Must().String()
+	})
+	return builder.BuildUnixFSDirectory(entries, ls)
+}
+
+func buildFileRange(fsPath string, offset, length int64, ls *ipld.LinkSystem) (ipld.Link, uint64, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	return builder.BuildUnixFSFile(io.NewSectionReader(f, offset, length), "", ls)
+}
+
+// WriteManifest writes the manifest as JSON
+func (m *Manifest) WriteManifest(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ReadManifest reads a manifest written by WriteManifest
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,158 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filswan/go-swan-lib/model"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	_ "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// CarInfo describes a CAR file prepared for a storage deal
+type CarInfo struct {
+	Source     string              `json:"source"`      // file or directory the CAR was built from
+	CarPath    string              `json:"car_path"`    // path of the CARv1 file
+	PayloadCid cid.Cid             `json:"payload_cid"` // root CID of the CAR
+	PieceCid   cid.Cid             `json:"piece_cid"`   // CommP of the CAR
+	PieceSize  abi.PaddedPieceSize `json:"piece_size"`  // size of the CAR as a padded piece
+	CarSize    uint64              `json:"car_size"`    // unpadded size of the CAR file
+}
+
+// proxyRoot is the root written in the CAR header while the DAG is built. It
+// has the same length as the real root, so the header can be rewritten in place.
+var proxyRoot cid.Cid
+
+func init() {
+	var err error
+	proxyRoot, err = cid.NewPrefixV1(cid.DagProtobuf, multihash.SHA2_256).Sum([]byte{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// PrepareCar builds a UnixFS DAG of the file or directory at src into the
+// CARv1 file at carPath and computes its CommP. Both the file contents and the
+// CAR are streamed, so memory use does not grow with the size of the data.
+func PrepareCar(ctx context.Context, src, carPath string) (*CarInfo, error) {
+	info, err := writeCar(ctx, carPath, func(ls *ipld.LinkSystem) (ipld.Link, error) {
+		lnk, _, err := builder.BuildUnixFSRecursive(src, ls)
+		return lnk, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Source = src
+	return info, nil
+}
+
+// writeCar writes the DAG created by build into a CARv1 file and computes the
+// CommP of the file
+func writeCar(ctx context.Context, carPath string, build func(ls *ipld.LinkSystem) (ipld.Link, error)) (*CarInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(carPath), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(carPath)
+	if err != nil {
+		return nil, fmt.Errorf("creating car file %s: %w", carPath, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	car, err := storage.NewWritable(f, []cid.Cid{proxyRoot}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		return nil, fmt.Errorf("opening car file %s: %w", carPath, err)
+	}
+
+	ls := cidlink.DefaultLinkSystem()
+	ls.TrustedStorage = true
+	ls.SetWriteStorage(car)
+
+	root, err := build(&ls)
+	if err != nil {
+		return nil, fmt.Errorf("building dag for %s: %w", carPath, err)
+	}
+	if err := car.Finalize(); err != nil {
+		return nil, fmt.Errorf("finalizing car file %s: %w", carPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	payloadCid := root.(cidlink.Link).Cid
+	if err := carv2.ReplaceRootsInFile(carPath, []cid.Cid{payloadCid}); err != nil {
+		return nil, fmt.Errorf("writing root %s to car file %s: %w", payloadCid, carPath, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pieceCid, pieceSize, carSize, err := CommPFile(carPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CarInfo{
+		CarPath:    carPath,
+		PayloadCid: payloadCid,
+		PieceCid:   pieceCid,
+		PieceSize:  pieceSize,
+		CarSize:    carSize,
+	}, nil
+}
+
+// CommPFile computes the piece CID and padded piece size of a file, and returns
+// its size
+func CommPFile(path string) (cid.Cid, abi.PaddedPieceSize, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	return CommP(f)
+}
+
+// CommP streams the data through the piece commitment hasher and returns the
+// piece CID, the padded piece size and the number of bytes read
+func CommP(r io.Reader) (cid.Cid, abi.PaddedPieceSize, uint64, error) {
+	cp := new(commp.Calc)
+	size, err := io.Copy(cp, r)
+	if err != nil {
+		return cid.Undef, 0, 0, fmt.Errorf("computing commp: %w", err)
+	}
+
+	rawCommP, paddedSize, err := cp.Digest()
+	if err != nil {
+		return cid.Undef, 0, 0, fmt.Errorf("computing commp: %w", err)
+	}
+
+	pieceCid, err := commcid.DataCommitmentV1ToCID(rawCommP)
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	return pieceCid, abi.PaddedPieceSize(paddedSize), uint64(size), nil
+}
+
+// DealConfig returns a copy of the deal config with the payload, piece and
+// size of the CAR filled in
+func (c *CarInfo) DealConfig(base model.DealConfig) *model.DealConfig {
+	base.PayloadCid = c.PayloadCid.String()
+	base.PieceCid = c.PieceCid.String()
+	base.FileSize = int64(c.CarSize)
+	return &base
+}
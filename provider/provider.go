@@ -7,7 +7,6 @@ import (
 	"github.com/filecoin-project/boost/storagemarket/types/legacytypes"
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
-	"github.com/filecoin-project/go-state-types/builtin"
 	"github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
 	"github.com/filswan/swan-boost-lib/client"
 	myask "github.com/filswan/swan-boost-lib/storedask"
@@ -28,7 +27,8 @@ import (
 )
 
 type Client struct {
-	stub boostapi.BoostStruct
+	stub         boostapi.BoostStruct
+	startPlanner *client.StartEpochPlanner
 }
 
 func NewClient(authToken, apiUrl string) (*Client, jsonrpc.ClientCloser, error) {
@@ -51,6 +51,15 @@ func NewClient(authToken, apiUrl string) (*Client, jsonrpc.ClientCloser, error)
 	}, closer, nil
 }
 
+// WithStartEpochPlanner sets the planner of the start epoch of direct deals.
+// There is no client deal history for direct deals, so the planner uses the
+// advertised and default delays only. By default, direct deals start
+// client.DefaultStartEpochDelay after the head.
+func (pc *Client) WithStartEpochPlanner(planner *client.StartEpochPlanner) *Client {
+	pc.startPlanner = planner
+	return pc
+}
+
 func (pc *Client) OfflineDealWithData(ctx context.Context, dealUuid, filePath string, isDelete bool) (*DealRejectionInfo, error) {
 	dealUid, err := uuid.Parse(dealUuid)
 	if err != nil {
@@ -97,7 +106,7 @@ func (pc *Client) MarketSetAsk(ctx context.Context, boostRepo string, fullNodeUr
 
 	qty := dur.Seconds() / float64(build.BlockDelaySecs)
 
-	miner, err := address.NewFromString(minerId)
+	miner, err := client.ParseAddress(minerId)
 	if err != nil {
 		return fmt.Errorf("converting miner ID from config: %w", err)
 	}
@@ -138,7 +147,7 @@ func (pc *Client) BoostDirectDeal(ctx context.Context, boostRepo string, fullNod
 		return nil, fmt.Errorf("getting chain head: %w", err)
 	}
 
-	clientAddr, err := address.NewFromString(walletAddress)
+	clientAddr, err := client.ParseAddress(walletAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse clientaddr param: %w", err)
 	}
@@ -152,8 +161,6 @@ func (pc *Client) BoostDirectDeal(ctx context.Context, boostRepo string, fullNod
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse allocationId param: %w", err)
 	}
-	startEpoch := head.Height() + (builtin.EpochsInDay * 2)
-
 	alloc, err := fullNodeApi.StateGetAllocation(ctx, clientAddr, verifreg.AllocationId(allocationIdUnit), head.Key())
 	if err != nil {
 		return nil, fmt.Errorf("getting claim details from chain: %w", err)
@@ -163,6 +170,15 @@ func (pc *Client) BoostDirectDeal(ctx context.Context, boostRepo string, fullNod
 		return nil, fmt.Errorf("no allocation found with ID %d", allocationId)
 	}
 
+	startEpoch := head.Height() + client.DefaultStartEpochDelay
+	if pc.startPlanner != nil {
+		providerAddr, err := address.NewIDAddress(uint64(alloc.Provider))
+		if err != nil {
+			return nil, err
+		}
+		startEpoch = head.Height() + pc.startPlanner.Delay(providerAddr.String(), alloc.Size, nil)
+	}
+
 	if alloc.Expiration < startEpoch {
 		return nil, fmt.Errorf("allocation will expire on %d before start epoch %d", alloc.Expiration, startEpoch)
 	}